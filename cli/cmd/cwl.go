@@ -1,11 +1,12 @@
 package cmd
 
 import (
-	"fmt"
 	"log"
 	"os"
 
+	"github.com/SerRichard/proteus/pkg/cwl"
 	"github.com/SerRichard/proteus/pkg/transpiler"
+	"github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
 	"github.com/spf13/cobra"
 )
 
@@ -14,6 +15,15 @@ func TranspileCommand() *cobra.Command {
 
 	var inputsFile string
 	var locationsFile string
+	var entrypoint string
+	var searchPath []string
+	var artifactRepository bool
+	var artifactRepositoryRef string
+	var volumeStrategy string
+	var volume transpiler.VolumeOptions
+	var archive string
+	var compressionLevel int32
+	var artifactGC string
 
 	command := &cobra.Command{
 		Use:   "transpile",
@@ -26,10 +36,28 @@ func TranspileCommand() *cobra.Command {
 				os.Exit(1)
 			}
 
-			fmt.Println("You can transpile this file, ", inputsFile, "locations", locationsFile)
+			// Logged to stderr as the transpiled workflow may be written to stdout
+			log.Println("You can transpile this file, ", inputsFile, "locations", locationsFile)
+
+			volume.Strategy = transpiler.VolumeStrategy(volumeStrategy)
+
+			artifacts := cwl.ArtifactOptions{
+				Archive:    cwl.ArchiveKind(archive),
+				ArtifactGC: v1alpha1.ArtifactGCStrategy(artifactGC),
+			}
+			if cmd.Flags().Changed("compression-level") {
+				artifacts.CompressionLevel = &compressionLevel
+			}
 
 			var mainFile = args[0]
-			err := transpiler.ProcessFile(mainFile, inputsFile, locationsFile)
+			err := transpiler.ProcessFileWithOptions(mainFile, inputsFile, locationsFile, transpiler.Options{
+				Entrypoint:            entrypoint,
+				SearchPath:            searchPath,
+				ArtifactRepository:    artifactRepository,
+				ArtifactRepositoryRef: artifactRepositoryRef,
+				Volume:                volume,
+				Artifacts:             artifacts,
+			})
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -39,6 +67,20 @@ func TranspileCommand() *cobra.Command {
 
 	command.Flags().StringVar(&inputsFile, "inputs", "", "Additional file defining any inputs for the main CWL file.")
 	command.Flags().StringVar(&locationsFile, "locations", "", "Additional file defining any loctions for the main CWL file.")
+	command.Flags().StringVar(&entrypoint, "entrypoint", "", "Process of a packed ($graph) CWL file to transpile, #main by default.")
+	command.Flags().StringSliceVar(&searchPath, "cwl-path", nil, "Directories searched for run files not found relative to the referencing CWL file.")
+
+	command.Flags().BoolVar(&artifactRepository, "artifact-repository", false, "Store outputs without a location in the artifact repository and read relative input locations as its keys.")
+	command.Flags().StringVar(&artifactRepositoryRef, "artifact-repository-ref", "", "Artifact repository used instead of the default one, as configmap[/key]. Implies --artifact-repository.")
+	command.Flags().StringVar(&volumeStrategy, "volume", "", "Volume strategy of the output directory: pvc, claim, emptydir or none. By default a pvc is claimed only when outputs need one.")
+	command.Flags().StringVar(&volume.StorageClass, "volume-storage-class", "", "Storage class of the claimed volume.")
+	command.Flags().StringVar(&volume.AccessMode, "volume-access-mode", "", "Access mode of the claimed volume, ReadWriteMany by default.")
+	command.Flags().StringVar(&volume.Size, "volume-size", "", "Size of the volume, outdirMin of the ResourceRequirement by default.")
+	command.Flags().StringVar(&volume.ClaimName, "volume-claim", "", "Existing claim mounted by the claim volume strategy.")
+	command.Flags().StringVar(&volume.GC, "volume-claim-gc", "", "volumeClaimGC strategy of the claimed volume: OnWorkflowCompletion or OnWorkflowSuccess.")
+	command.Flags().StringVar(&archive, "archive", "", "Archive of output artifacts: none, tar or zip. Files are stored raw and Directories as tar by default.")
+	command.Flags().Int32Var(&compressionLevel, "compression-level", 0, "Gzip compression level (0-9) of tar archived output artifacts.")
+	command.Flags().StringVar(&artifactGC, "artifact-gc", "", "artifactGC strategy of the workflow: OnWorkflowCompletion, OnWorkflowDeletion or Never.")
 
 	return command
 }
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/SerRichard/proteus/pkg/cwl"
+	"github.com/spf13/cobra"
+)
+
+// PackCommand bundles a CWL file and the files referenced by its steps into a
+// single packed document.
+func PackCommand() *cobra.Command {
+
+	var outputFile string
+	var searchPath []string
+
+	command := &cobra.Command{
+		Use:   "pack",
+		Short: "pack the provided CWL file and every referenced run file into a single $graph document",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+
+			// Local ontologies are referenced relative to the packed document,
+			// written to stdout it is expected in the working directory
+			outputDir := "."
+			if outputFile != "" {
+				outputDir = filepath.Dir(outputFile)
+			}
+
+			data, err := cwl.Pack(args[0], outputDir, searchPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if outputFile == "" {
+				_, err = os.Stdout.Write(data)
+			} else {
+				err = os.WriteFile(outputFile, data, 0644)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	command.Flags().StringVarP(&outputFile, "output", "o", "", "File to write the packed document to, stdout by default.")
+	command.Flags().StringSliceVar(&searchPath, "cwl-path", nil, "Directories searched for run files not found relative to the referencing CWL file.")
+
+	return command
+}
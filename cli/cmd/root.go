@@ -19,6 +19,7 @@ Proteus currently supports transpiling the following languages to Argo Workflows
 		},
 	}
 	command.AddCommand(TranspileCommand())
+	command.AddCommand(PackCommand())
 
 	return command
 }
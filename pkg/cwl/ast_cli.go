@@ -89,8 +89,8 @@ type WorkReuse struct {
 
 // NetworkAccess specifies network access requirements for a CWL tool.
 type NetworkAccess struct {
-	Class         string // constant NetworkAccess
-	NetworkAccess CWLExpression
+	Class         string        `yaml:"class"` // constant NetworkAccess
+	NetworkAccess CWLExpression `yaml:"networkAccess"`
 }
 
 // InplaceUpdateRequirement defines inplace update requirements for a CWL tool.
@@ -209,6 +209,30 @@ type CommandlineInputRecordSchema struct {
 	inputBinding *CommandlineBinding `yaml:"inputBinding"` //nolint:unused,structcheck
 }
 
+// CommandlineOutputRecordField represents a field in a command-line output record.
+type CommandlineOutputRecordField struct {
+	Name           string                    `yaml:"name"`
+	Type           CWLTypes                  `yaml:"type"`
+	Doc            Strings                   `yaml:"doc"`
+	Label          *string                   `yaml:"label"`
+	SecondaryFiles SecondaryFiles            `yaml:"secondaryFiles"`
+	Streamable     *bool                     `yaml:"streamable"`
+	Format         *CWLFormat                `yaml:"format"`
+	OutputBinding  *CommandlineOutputBinding `yaml:"outputBinding"`
+}
+
+// CommandlineOutputRecordFields holds the fields of a command-line output record.
+type CommandlineOutputRecordFields []CommandlineOutputRecordField
+
+// CommandlineOutputRecordSchema defines the schema for a command-line output record.
+type CommandlineOutputRecordSchema struct {
+	Type   string                        `yaml:"type"` // MUST BE "record"
+	Fields CommandlineOutputRecordFields `yaml:"fields"`
+	Label  *string                       `yaml:"label"`
+	Doc    Strings                       `yaml:"doc"`
+	Name   *string                       `yaml:"name"`
+}
+
 // Type represents a type used in command-line tools.
 type Type int32
 
@@ -232,11 +256,12 @@ const (
 
 // CWLType defines a CWL type used in command-line tools.
 type CWLType struct {
-	Kind   Type
-	Record *CommandlineInputRecordSchema
-	Enum   *CommandlineInputEnumSchema
-	Array  *CommandlineInputArraySchema
-	File   *CWLFile
+	Kind         Type
+	Record       *CommandlineInputRecordSchema
+	OutputRecord *CommandlineOutputRecordSchema
+	Enum         *CommandlineInputEnumSchema
+	Array        *CommandlineInputArraySchema
+	File         *CWLFile
 }
 
 // CWLTypes defines multiple CWL types.
@@ -248,7 +273,7 @@ type CommandlineBinding struct {
 	Position      *int          `yaml:"position"`
 	Prefix        *string       `yaml:"prefix"`
 	Separate      *bool         `yaml:"separate"`
-	ItemSeperator *string       `yaml:"itemSeperator"`
+	ItemSeparator *string       `yaml:"itemSeparator"`
 	ValueFrom     CWLExpression `yaml:"valueFrom"`
 	ShellQuote    *bool         `yaml:"bool"`
 }
@@ -260,7 +285,7 @@ type CommandlineInputParameter struct {
 	SecondaryFiles SecondaryFiles      `yaml:"secondaryFiles"` // len(1) == scalar while len > 1 == array
 	Streamable     *bool               `yaml:"streamable"`
 	Doc            Strings             `yaml:"doc"`
-	ID             *string             `yaml:"ID"`
+	ID             *string             `yaml:"id"`
 	Format         *CWLFormat          `yaml:"format"`
 	LoadContents   *bool               `yaml:"loadContents"`
 	LoadListing    *LoadListingEnum    `yaml:"loadListing"`
@@ -301,7 +326,7 @@ type CommandlineOutputParameter struct {
 	SecondaryFiles SecondaryFiles            `yaml:"secondaryFiles"`
 	Streamable     *bool                     `yaml:"streamable"`
 	Doc            Strings                   `yaml:"doc"`
-	ID             *string                   `yaml:"ID"`
+	ID             *string                   `yaml:"id"`
 	Format         *CWLFormat                `yaml:"format"`
 	OutputBinding  *CommandlineOutputBinding `yaml:"outputBinding"`
 }
@@ -326,26 +351,31 @@ type CommandlineArgument struct {
 type Inputs []CommandlineInputParameter
 type Outputs []CommandlineOutputParameter
 type Requirements []CWLRequirements
-type Hints struct {
-	Array []interface{}
-	Map   map[string]interface{}
-}
+type Hints []CWLRequirements
 type Arguments []string // CommandlineArgument
 
 type CommandLineTool struct {
-	Inputs       Inputs         `yaml:"inputs"`
-	Outputs      Outputs        `yaml:"outputs"`
-	Class        string         `yaml:"class"` // Must be "CommandLineTool"
-	ID           *string        `yaml:"id"`
-	Label        *string        `yaml:"label"`
-	Doc          Strings        `yaml:"doc"`
-	Requirements Requirements   `yaml:"requirements"`
-	Hints        Hints          `yaml:"hints"`
-	CWLVersion   *string        `yaml:"cwlVersion"`
-	Intent       Strings        `yaml:"intent"`
-	BaseCommand  Strings        `yaml:"baseCommand"`
-	Arguments    Arguments      `yaml:"arguments"`
-	Stdin        *CWLExpression `yaml:"stdin"`
-	Stderr       *CWLExpression `yaml:"stderr"`
-	Stdout       *CWLExpression `yaml:"stdout"`
+	Inputs       Inputs            `yaml:"inputs"`
+	Outputs      Outputs           `yaml:"outputs"`
+	Class        string            `yaml:"class"` // Must be "CommandLineTool"
+	ID           *string           `yaml:"id"`
+	Label        *string           `yaml:"label"`
+	Doc          Strings           `yaml:"doc"`
+	Requirements Requirements      `yaml:"requirements"`
+	Hints        Hints             `yaml:"hints"`
+	CWLVersion   *string           `yaml:"cwlVersion"`
+	Namespaces   map[string]string `yaml:"$namespaces"`
+	Schemas      Strings           `yaml:"$schemas"`
+	Intent       Strings           `yaml:"intent"`
+	BaseCommand  Strings           `yaml:"baseCommand"`
+	Arguments    Arguments         `yaml:"arguments"`
+	Stdin        *CWLExpression    `yaml:"stdin"`
+	Stderr       *CWLExpression    `yaml:"stderr"`
+	Stdout       *CWLExpression    `yaml:"stdout"`
+	// exit codes overriding the default of 0 meaning success
+	SuccessCodes       []int `yaml:"successCodes"`
+	TemporaryFailCodes []int `yaml:"temporaryFailCodes"`
+	PermanentFailCodes []int `yaml:"permanentFailCodes"`
+	// Ontology is loaded from Schemas when the document is read
+	Ontology *Ontology `yaml:"-"`
 }
@@ -71,26 +71,39 @@ type CWLInputProvider interface {
 }
 
 type CWLFile struct {
-	Class          string     `yaml:"class"` // constant value File
-	Location       *string    `yaml:"location"`
-	Path           *string    `yaml:"path"`
-	Dirname        *string    `yaml:"dirname"`
-	Nameroot       *string    `yaml:"nameroot"`
-	Nameext        *string    `yaml:"nameext"`
-	Checksum       *string    `yaml:"checksum"`
-	Size           *int64     `yaml:"size"`
-	SecondaryFiles []CWLFile  `yaml:"secondaryFiles"`
-	Format         *CWLFormat `yaml:"format"`
-	Contents       *string    `yaml:"contents"`
+	Class          string          `yaml:"class"` // constant value File
+	Location       *string         `yaml:"location"`
+	Path           *string         `yaml:"path"`
+	Basename       *string         `yaml:"basename"`
+	Dirname        *string         `yaml:"dirname"`
+	Nameroot       *string         `yaml:"nameroot"`
+	Nameext        *string         `yaml:"nameext"`
+	Checksum       *string         `yaml:"checksum"`
+	Size           *int64          `yaml:"size"`
+	SecondaryFiles []CWLInputEntry `yaml:"secondaryFiles"` // Files or Directories
+	Format         *CWLFormat      `yaml:"format"`
+	Contents       *string         `yaml:"contents"`
 }
 
+type CWLDirectory struct {
+	Class    string          `yaml:"class"` // constant value Directory
+	Location *string         `yaml:"location"`
+	Path     *string         `yaml:"path"`
+	Basename *string         `yaml:"basename"`
+	Listing  []CWLInputEntry `yaml:"listing"` // Files or Directories
+}
+
+// CWLInputEntry is a value of a job order, Kind tells which field is set.
 type CWLInputEntry struct {
-	Kind       Type
-	FileData   *CWLFile
-	BoolData   *bool
-	StringData *string
-	IntData    *int
-	Array      *[]any
+	Kind          Type
+	FileData      *CWLFile
+	DirectoryData *CWLDirectory
+	BoolData      *bool
+	StringData    *string
+	IntData       *int
+	FloatData     *float64
+	Array         []CWLInputEntry
+	Record        map[string]CWLInputEntry
 }
 
 type LoadListingEnum string
@@ -104,66 +117,145 @@ const (
 type FileLocationKind string
 
 const (
-	HTTPKind FileLocationKind = "http"
-	S3Kind   FileLocationKind = "s3"
-	GITKind  FileLocationKind = "git"
+	HTTPKind        FileLocationKind = "http"
+	S3Kind          FileLocationKind = "s3"
+	GITKind         FileLocationKind = "git"
+	GCSKind         FileLocationKind = "gcs"
+	OSSKind         FileLocationKind = "oss"
+	HDFSKind        FileLocationKind = "hdfs"
+	AzureKind       FileLocationKind = "azure"
+	ArtifactoryKind FileLocationKind = "artifactory"
+	RawLocationKind FileLocationKind = "raw"
 )
 
 type FileLocationData struct {
-	Name string                 `json:"name"`
-	Type FileLocationKind       `json:"type"`
-	HTTP *v1alpha1.HTTPArtifact `json:"http"`
-	S3   *v1alpha1.S3Artifact   `json:"s3"`
-	HDFS *v1alpha1.HDFSArtifact `json:"hdfs"`
+	Name        string                        `json:"name"`
+	Type        FileLocationKind              `json:"type"`
+	HTTP        *v1alpha1.HTTPArtifact        `json:"http"`
+	S3          *v1alpha1.S3Artifact          `json:"s3"`
+	HDFS        *v1alpha1.HDFSArtifact        `json:"hdfs"`
+	GCS         *v1alpha1.GCSArtifact         `json:"gcs"`
+	OSS         *v1alpha1.OSSArtifact         `json:"oss"`
+	Azure       *v1alpha1.AzureArtifact       `json:"azure"`
+	Git         *v1alpha1.GitArtifact         `json:"git"`
+	Artifactory *v1alpha1.ArtifactoryArtifact `json:"artifactory"`
+	Raw         *v1alpha1.RawArtifact         `json:"raw"`
+	// ArtifactOptions of an output override those of the locations file
+	ArtifactOptions
+}
+
+type ArchiveKind string
+
+const (
+	ArchiveNone ArchiveKind = "none"
+	ArchiveTar  ArchiveKind = "tar"
+	ArchiveZip  ArchiveKind = "zip"
+)
+
+// ArtifactOptions control how output artifacts are archived and garbage
+// collected, unset fields fall back to the defaults of the artifact type.
+type ArtifactOptions struct {
+	Archive          ArchiveKind                 `json:"archive"`
+	CompressionLevel *int32                      `json:"compressionLevel"`
+	ArtifactGC       v1alpha1.ArtifactGCStrategy `json:"artifactGC"`
 }
 
 type FileLocations struct {
 	Inputs  map[string]FileLocationData `json:"inputs"`
 	Outputs map[string]FileLocationData `json:"outputs"`
+	// ArtifactRepository stores artifacts without a location as key-only
+	// artifacts in the artifact repository of the cluster, the default one
+	// unless the reference names another
+	ArtifactRepository *v1alpha1.ArtifactRepositoryRef `json:"artifactRepository"`
+	// Artifacts holds the options of every output artifact
+	Artifacts ArtifactOptions `json:"artifacts"`
 }
 
 func (cwlInputEntry *CWLInputEntry) UnmarshalYAML(value *yaml.Node) error {
-
-	var b bool
-	err := value.Decode(&b)
-	if err == nil {
-		cwlInputEntry.Kind = CWLBoolKind
-		cwlInputEntry.BoolData = &b
-		return nil
+	if value.Kind == yaml.AliasNode {
+		value = value.Alias
 	}
 
-	var i int
-	err = value.Decode(&i)
-	if err == nil {
-		cwlInputEntry.Kind = CWLIntKind
-		cwlInputEntry.IntData = &i
-		return nil
-	}
+	switch value.Kind {
+	case yaml.ScalarNode:
+		switch value.ShortTag() {
+		case "!!null":
+			cwlInputEntry.Kind = CWLNullKind
+			return nil
+		case "!!bool":
+			var b bool
+			if err := value.Decode(&b); err != nil {
+				return err
+			}
+			cwlInputEntry.Kind = CWLBoolKind
+			cwlInputEntry.BoolData = &b
+			return nil
+		case "!!int":
+			var i int
+			if err := value.Decode(&i); err != nil {
+				return err
+			}
+			cwlInputEntry.Kind = CWLIntKind
+			cwlInputEntry.IntData = &i
+			return nil
+		case "!!float":
+			var f float64
+			if err := value.Decode(&f); err != nil {
+				return err
+			}
+			cwlInputEntry.Kind = CWLFloatKind
+			cwlInputEntry.FloatData = &f
+			return nil
+		}
 
-	var s string
-	err = value.Decode(&s)
-	if err == nil {
+		var s string
+		if err := value.Decode(&s); err != nil {
+			return err
+		}
 		cwlInputEntry.Kind = CWLStringKind
 		cwlInputEntry.StringData = &s
 		return nil
-	}
-
-	var arr []any
-	err = value.Decode(&arr)
-	if err == nil {
+	case yaml.SequenceNode:
+		arr := make([]CWLInputEntry, 0)
+		if err := value.Decode(&arr); err != nil {
+			return err
+		}
 		cwlInputEntry.Kind = CWLArrayKind
-		cwlInputEntry.Array = &arr
+		cwlInputEntry.Array = arr
 		return nil
-	}
+	case yaml.MappingNode:
+		var object struct {
+			Class *string `yaml:"class"`
+		}
+		if err := value.Decode(&object); err != nil {
+			return err
+		}
 
-	var file CWLFile
-	err = value.Decode(&file)
-	if err == nil {
-		if file.Class != "File" {
-			return fmt.Errorf("%s was received instead of ", file.Class)
+		switch {
+		case object.Class == nil:
+			record := make(map[string]CWLInputEntry)
+			if err := value.Decode(&record); err != nil {
+				return err
+			}
+			cwlInputEntry.Kind = CWLRecordKind
+			cwlInputEntry.Record = record
+		case *object.Class == "File":
+			var file CWLFile
+			if err := value.Decode(&file); err != nil {
+				return err
+			}
+			cwlInputEntry.Kind = CWLFileKind
+			cwlInputEntry.FileData = &file
+		case *object.Class == "Directory":
+			var directory CWLDirectory
+			if err := value.Decode(&directory); err != nil {
+				return err
+			}
+			cwlInputEntry.Kind = CWLDirectoryKind
+			cwlInputEntry.DirectoryData = &directory
+		default:
+			return fmt.Errorf("%s was received instead of File or Directory", *object.Class)
 		}
-		cwlInputEntry.Kind = CWLFileKind
-		cwlInputEntry.FileData = &file
 		return nil
 	}
 
@@ -190,12 +282,64 @@ func (f *FileLocationData) UnmarshalJSON(data []byte) error {
 		if tmp.S3 == nil {
 			return errors.New("s3 data not provided")
 		}
+	case GCSKind:
+		if tmp.GCS == nil {
+			return errors.New("gcs data not provided")
+		}
+	case OSSKind:
+		if tmp.OSS == nil {
+			return errors.New("oss data not provided")
+		}
+	case HDFSKind:
+		if tmp.HDFS == nil {
+			return errors.New("hdfs data not provided")
+		}
+	case AzureKind:
+		if tmp.Azure == nil {
+			return errors.New("azure data not provided")
+		}
+	case GITKind:
+		if tmp.Git == nil {
+			return errors.New("git data not provided")
+		}
+	case ArtifactoryKind:
+		if tmp.Artifactory == nil {
+			return errors.New("artifactory data not provided")
+		}
+	case RawLocationKind:
+		if tmp.Raw == nil {
+			return errors.New("raw data not provided")
+		}
 	default:
 		return fmt.Errorf("%s is not a valid type", tmp.Type)
 	}
-	f.Name = tmp.Name
-	f.Type = tmp.Type
-	f.HTTP = tmp.HTTP
-	f.S3 = tmp.S3
+	*f = FileLocationData(tmp)
 	return nil
 }
+
+// ArtifactLocation returns the argo location of the artifact, only the
+// driver named by Type is set.
+func (f FileLocationData) ArtifactLocation() v1alpha1.ArtifactLocation {
+	location := v1alpha1.ArtifactLocation{}
+	switch f.Type {
+	case HTTPKind:
+		location.HTTP = f.HTTP
+	case S3Kind:
+		location.S3 = f.S3
+	case HDFSKind:
+		location.HDFS = f.HDFS
+	case GCSKind:
+		location.GCS = f.GCS
+	case OSSKind:
+		location.OSS = f.OSS
+	case AzureKind:
+		location.Azure = f.Azure
+	case GITKind:
+		location.Git = f.Git
+	case ArtifactoryKind:
+		location.Artifactory = f.Artifactory
+	case RawLocationKind:
+		location.Raw = f.Raw
+	}
+	return location
+}
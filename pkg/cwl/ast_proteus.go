@@ -0,0 +1,48 @@
+package cwl
+
+import (
+	"encoding/json"
+
+	"gopkg.in/yaml.v3"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+const (
+	// ProteusNamespace is the IRI the proteus extensions are declared under in $namespaces.
+	ProteusNamespace = "https://github.com/SerRichard/proteus#"
+	// KubernetesPodClass is the local name of the hint carrying kubernetes pod settings.
+	KubernetesPodClass = "KubernetesPod"
+)
+
+// KubernetesPod sets kubernetes pod settings on the emitted templates. At the
+// workflow level it sets the defaults of the whole workflow.
+type KubernetesPod struct {
+	Class              string                       `json:"class"`
+	NodeSelector       map[string]string            `json:"nodeSelector"`
+	Tolerations        []apiv1.Toleration           `json:"tolerations"`
+	Affinity           *apiv1.Affinity              `json:"affinity"`
+	ServiceAccountName string                       `json:"serviceAccountName"`
+	PriorityClassName  string                       `json:"priorityClassName"`
+	ImagePullSecrets   []apiv1.LocalObjectReference `json:"imagePullSecrets"`
+	SecurityContext    *apiv1.PodSecurityContext    `json:"securityContext"`
+}
+
+func (KubernetesPod) isCWLRequirement()  {}
+func (d KubernetesPod) getClass() string { return d.Class }
+
+// UnmarshalYAML decodes the pod settings through JSON, as the kubernetes
+// types only carry JSON field names.
+func (k *KubernetesPod) UnmarshalYAML(value *yaml.Node) error {
+	var raw map[string]interface{}
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+
+	type rawKubernetesPod KubernetesPod
+	return json.Unmarshal(data, (*rawKubernetesPod)(k))
+}
@@ -60,25 +60,20 @@ type WorkflowInputParameter struct {
 
 type WorkflowOutputParameterType interface{}
 
-type LinkMergeMethod interface {
-	isLinkMergeMethod()
-}
-type MergeNested struct{}
-type MergeFlattened struct{}
+type LinkMergeMethod string
 
-func (_ MergeNested) isLinkMergeMethod()    {}
-func (_ MergeFlattened) isLinkMergeMethod() {}
+const (
+	MergeNested    LinkMergeMethod = "merge_nested"
+	MergeFlattened LinkMergeMethod = "merge_flattened"
+)
 
-type PickValueMethod interface {
-	isPickValueMethod()
-}
-type FirstNonNull struct{}
-type TheOnlyNonNull struct{}
-type AllNonNull struct{}
+type PickValueMethod string
 
-func (_ FirstNonNull) isPickValueMethod()   {}
-func (_ TheOnlyNonNull) isPickValueMethod() {}
-func (_ AllNonNull) isPickValueMethod()     {}
+const (
+	FirstNonNull   PickValueMethod = "first_non_null"
+	TheOnlyNonNull PickValueMethod = "the_only_non_null"
+	AllNonNull     PickValueMethod = "all_non_null"
+)
 
 type SharedWorkflowOutputSumTypes interface {
 	isSharedWorkflowOutputSumType()
@@ -103,26 +98,26 @@ type OutputRecordSchema struct {
 }
 
 type WorkflowOutputParameter struct {
-	Type           CWLTypes
-	Label          *string
-	SecondaryFiles []CWLSecondaryFileSchema
-	Streamable     *bool
-	Doc            []string
-	Id             *string
-	Format         *CWLFormat
-	OutputSource   []string
-	LinkMerge      *LinkMergeMethod
-	PickValue      *PickValueMethod
+	Type           CWLTypes                 `yaml:"type"`
+	Label          *string                  `yaml:"label"`
+	SecondaryFiles []CWLSecondaryFileSchema `yaml:"secondaryFiles"`
+	Streamable     *bool                    `yaml:"streamable"`
+	Doc            Strings                  `yaml:"doc"`
+	Id             *string                  `yaml:"id"`
+	Format         *CWLFormat               `yaml:"format"`
+	OutputSource   Strings                  `yaml:"outputSource"`
+	LinkMerge      *LinkMergeMethod         `yaml:"linkMerge"`
+	PickValue      *PickValueMethod         `yaml:"pickValue"`
 }
 
 type WorkflowStepInput struct {
 	Id           *string          `yaml:"id"`
-	Source       *string          `yaml:"source"`
-	LinkMerge    *string          `yaml:"linkMerge"`
+	Source       Strings          `yaml:"source"`
+	LinkMerge    *LinkMergeMethod `yaml:"linkMerge"`
 	LoadContents *bool            `yaml:"loadContents"`
 	LoadListing  *LoadListingEnum `yaml:"loadListing"`
 	Label        *string          `yaml:"label"`
-	Default      *string          `yaml:"default"`
+	Default      *string          `yaml:"-"` // parameter value, non-scalars are JSON encoded
 	ValueFrom    *CWLExpression   `yaml:"valueFrom"`
 }
 
@@ -145,13 +140,19 @@ type ScatterFeatureRequirement struct {
 	Class string // constant ScatterFeatureRequirement
 }
 type MultipleInputFeatureRequirement struct {
-	Class string // constant MultipleInputFeatureRequirement
+	Class string `yaml:"class"` // constant MultipleInputFeatureRequirement
 }
 
+func (MultipleInputFeatureRequirement) isCWLRequirement()  {}
+func (d MultipleInputFeatureRequirement) getClass() string { return d.Class }
+
 type StepInputExpressionRequirement struct {
-	Class string // constant StepInputExpressionRequirement
+	Class string `yaml:"class"` // constant StepInputExpressionRequirement
 }
 
+func (StepInputExpressionRequirement) isCWLRequirement()  {}
+func (d StepInputExpressionRequirement) getClass() string { return d.Class }
+
 type ScatterMethod string
 
 const (
@@ -194,15 +195,19 @@ type WorkflowOutputs map[string]WorkflowOutputParameter
 type WorkflowSteps []WorkflowStep
 
 type Workflow struct {
-	Inputs       WorkflowInputs  `yaml:"inputs"`
-	Outputs      WorkflowOutputs `yaml:"outputs"`
-	Class        string          `yaml:"class"` // Only Workflow
-	Steps        WorkflowSteps   `yaml:"steps"`
-	Id           *string         `yaml:"id"`
-	Label        *string         `yaml:"label"`
-	Doc          []string        `yaml:"doc"`
-	Requirements Requirements    `yaml:"requirements"`
-	Hints        Hints           `yaml:"hints"`
-	CWLVersion   *string         `yaml:"cwlVersion"`
-	Intent       []string        `yaml:"intent"`
+	Inputs       WorkflowInputs    `yaml:"inputs"`
+	Outputs      WorkflowOutputs   `yaml:"outputs"`
+	Class        string            `yaml:"class"` // Only Workflow
+	Steps        WorkflowSteps     `yaml:"steps"`
+	Id           *string           `yaml:"id"`
+	Label        *string           `yaml:"label"`
+	Doc          []string          `yaml:"doc"`
+	Requirements Requirements      `yaml:"requirements"`
+	Hints        Hints             `yaml:"hints"`
+	CWLVersion   *string           `yaml:"cwlVersion"`
+	Intent       []string          `yaml:"intent"`
+	Namespaces   map[string]string `yaml:"$namespaces"`
+	Schemas      Strings           `yaml:"$schemas"`
+	// Ontology is loaded from Schemas when the document is read
+	Ontology *Ontology `yaml:"-"`
 }
@@ -3,8 +3,10 @@ package cwl
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 
+	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 )
 
@@ -23,7 +25,7 @@ func getCWLExpressionInner(str string) *string {
 	if str[0] != '$' {
 		return nil
 	}
-	if str[1] == '[' && str[len(str)-1] == ']' {
+	if str[1] == '(' && str[len(str)-1] == ')' {
 		return &str
 	}
 	if str[1] == '{' && str[len(str)-1] == '}' {
@@ -64,8 +66,28 @@ func (tys *CWLTypes) UnmarshalYAML(value *yaml.Node) error {
 		}
 		var ty CWLType
 
-		if strings.Contains(s, "[]") {
-			s = "array"
+		// An optional type also accepts null
+		if strings.HasSuffix(s, "?") {
+			var inner CWLTypes
+			itemNode := yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: strings.TrimSuffix(s, "?")}
+			if err := itemNode.Decode(&inner); err != nil {
+				return err
+			}
+			newTys = append(newTys, inner...)
+			newTys = append(newTys, CWLType{Kind: CWLNullKind})
+			break
+		}
+
+		if strings.HasSuffix(s, "[]") {
+			var items CWLTypes
+			itemNode := yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: strings.TrimSuffix(s, "[]")}
+			if err := itemNode.Decode(&items); err != nil {
+				return err
+			}
+			ty.Kind = CWLArrayKind
+			ty.Array = &CommandlineInputArraySchema{Type: "array", Items: items}
+			newTys = append(newTys, ty)
+			break
 		}
 
 		switch s {
@@ -94,10 +116,48 @@ func (tys *CWLTypes) UnmarshalYAML(value *yaml.Node) error {
 		}
 		newTys = append(newTys, ty)
 	case yaml.MappingNode:
-		fmt.Println("MappingNode ", value)
-		return errors.New("complex types not supported yet")
+		var schema struct {
+			Type string `yaml:"type"`
+		}
+		if err := value.Decode(&schema); err != nil {
+			return err
+		}
+		var ty CWLType
+		switch schema.Type {
+		case "record":
+			var record CommandlineOutputRecordSchema
+			if err := value.Decode(&record); err != nil {
+				return err
+			}
+			ty.Kind = CWLRecordKind
+			ty.OutputRecord = &record
+		case "array":
+			var array CommandlineInputArraySchema
+			if err := value.Decode(&array); err != nil {
+				return err
+			}
+			ty.Kind = CWLArrayKind
+			ty.Array = &array
+		case "enum":
+			var enum CommandlineInputEnumSchema
+			if err := value.Decode(&enum); err != nil {
+				return err
+			}
+			ty.Kind = CWLEnumKind
+			ty.Enum = &enum
+		default:
+			return fmt.Errorf("complex type %s not supported yet", schema.Type)
+		}
+		newTys = append(newTys, ty)
 	case yaml.SequenceNode:
-		return errors.New("array types not supported yet")
+		// A union of the listed types
+		for _, item := range value.Content {
+			var union CWLTypes
+			if err := item.Decode(&union); err != nil {
+				return err
+			}
+			newTys = append(newTys, union...)
+		}
 	default:
 		return errors.New("type not supported")
 	}
@@ -129,10 +189,46 @@ func (format *CWLFormat) UnmarshalYAML(value *yaml.Node) error {
 	}
 }
 
-// UnmarshalYAML decodes YAML data into a CommandlineInputParameter object.
+// UnmarshalYAML decodes YAML data into a CommandlineOutputRecordFields object.
+func (fields *CommandlineOutputRecordFields) UnmarshalYAML(value *yaml.Node) error {
+	newFields := make([]CommandlineOutputRecordField, 0)
+	switch value.Kind {
+	case yaml.MappingNode:
+		// Preserve the order of the fields as declared, a string or a list is
+		// the shorthand for the type of the field
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			var field CommandlineOutputRecordField
+			node := value.Content[i+1]
+			if node.Kind == yaml.ScalarNode || node.Kind == yaml.SequenceNode {
+				if err := node.Decode(&field.Type); err != nil {
+					return err
+				}
+			} else if err := node.Decode(&field); err != nil {
+				return err
+			}
+			field.Name = value.Content[i].Value
+			newFields = append(newFields, field)
+		}
+	case yaml.SequenceNode:
+		if err := value.Decode(&newFields); err != nil {
+			return err
+		}
+	default:
+		return errors.New("sequence or mapping type expected")
+	}
+	*fields = newFields
+	return nil
+}
+
+// UnmarshalYAML decodes YAML data into a CommandlineInputParameter object. A
+// string or a list is the shorthand for the type of the input.
 func (input *CommandlineInputParameter) UnmarshalYAML(value *yaml.Node) error {
 	type rawParamType CommandlineInputParameter
 
+	if value.Kind == yaml.ScalarNode || value.Kind == yaml.SequenceNode {
+		return value.Decode(&input.Type)
+	}
+
 	err := value.Decode((*rawParamType)(input))
 	if err != nil {
 		return err
@@ -145,13 +241,13 @@ func (inp *Inputs) UnmarshalYAML(value *yaml.Node) error {
 	inputs := make([]CommandlineInputParameter, 0)
 	switch value.Kind {
 	case yaml.MappingNode:
-		m := make(map[string]CommandlineInputParameter)
-		err := value.Decode(&m)
-		if err != nil {
-			return err
-		}
-		for key, input := range m {
-			newKey := key
+		// Preserve the order of the inputs as declared
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			var input CommandlineInputParameter
+			if err := value.Content[i+1].Decode(&input); err != nil {
+				return err
+			}
+			newKey := value.Content[i].Value
 			input.ID = &newKey
 			inputs = append(inputs, input)
 		}
@@ -174,13 +270,13 @@ func (out *Outputs) UnmarshalYAML(value *yaml.Node) error {
 
 	switch value.Kind {
 	case yaml.MappingNode:
-		m := make(map[string]CommandlineOutputParameter)
-		err := value.Decode(&m)
-		if err != nil {
-			return err
-		}
-		for key, output := range m {
-			newKey := key
+		// Preserve the order of the outputs as declared
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			var output CommandlineOutputParameter
+			if err := value.Content[i+1].Decode(&output); err != nil {
+				return err
+			}
+			newKey := value.Content[i].Value
 			output.ID = &newKey
 			outputs = append(outputs, output)
 		}
@@ -216,67 +312,143 @@ func (ir *intermediateRepr) UnmarshalYAML(value *yaml.Node) error {
 	return nil
 }
 
-// UnmarshalYAML decodes YAML data into a Requirements object.
-func (reqs *Requirements) UnmarshalYAML(value *yaml.Node) error {
+// errUnsupportedClass is returned for requirement classes proteus can not apply.
+var errUnsupportedClass = errors.New("unsupported class")
+
+// decodeRequirement decodes a requirement or hint of the given class into its
+// typed struct.
+func decodeRequirement(class string, node *yaml.Node) (CWLRequirements, error) {
+	switch class {
+	case "DockerRequirement":
+		var d DockerRequirement
+		err := node.Decode(&d)
+		d.Class = class
+		return d, err
+	case "ResourceRequirement":
+		var r ResourceRequirement
+		err := node.Decode(&r)
+		r.Class = class
+		return r, err
+	case "ToolTimeLimit":
+		var t ToolTimeLimit
+		err := node.Decode(&t)
+		t.Class = class
+		return t, err
+	case "MultipleInputFeatureRequirement":
+		var m MultipleInputFeatureRequirement
+		err := node.Decode(&m)
+		m.Class = class
+		return m, err
+	case "StepInputExpressionRequirement":
+		var e StepInputExpressionRequirement
+		err := node.Decode(&e)
+		e.Class = class
+		return e, err
+	case "LoadListingRequirement":
+		var l LoadListingRequirement
+		err := node.Decode(&l)
+		l.Class = class
+		return l, err
+	case "NetworkAccess":
+		var n NetworkAccess
+		err := node.Decode(&n)
+		n.Class = class
+		return n, err
+	}
+
+	if isKubernetesPodClass(class) {
+		var k KubernetesPod
+		err := node.Decode(&k)
+		k.Class = class
+		return k, err
+	}
+	return nil, errUnsupportedClass
+}
+
+// isKubernetesPodClass matches the proteus pod settings class, either by its
+// full IRI or by a prefix declared in $namespaces.
+func isKubernetesPodClass(class string) bool {
+	if class == ProteusNamespace+KubernetesPodClass {
+		return true
+	}
+	prefix, name, found := strings.Cut(class, ":")
+	return found && prefix != "" && name == KubernetesPodClass
+}
+
+// decodeClasses decodes a list of requirements or a map keyed by class.
+func decodeClasses(value *yaml.Node) (map[string]intermediateRepr, error) {
 	rs := make(map[string]intermediateRepr, 0)
 	err := value.Decode(&rs)
 	if err != nil {
 		rsArray := make([]intermediateRepr, 0)
 		err = value.Decode(&rsArray)
 		if err != nil {
-			return errors.New("[]requirement or map[class]requirement was expected")
+			return nil, errors.New("[]requirement or map[class]requirement was expected")
 		}
 		for _, req := range rsArray {
 			if req.Class == nil {
-				return errors.New("class expected")
+				return nil, errors.New("class expected")
 			}
 			rs[*req.Class] = req
 		}
 	}
+	return rs, nil
+}
+
+// sortedClasses returns the classes in a stable order.
+func sortedClasses(rs map[string]intermediateRepr) []string {
+	classes := make([]string, 0)
+	for class := range rs {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	return classes
+}
+
+// UnmarshalYAML decodes YAML data into a Requirements object.
+func (reqs *Requirements) UnmarshalYAML(value *yaml.Node) error {
+	rs, err := decodeClasses(value)
+	if err != nil {
+		return err
+	}
 
 	newRequests := make([]CWLRequirements, 0)
-	for class, req := range rs {
-		switch class {
-		case "DockerRequirement":
-			var d DockerRequirement
-			err := req.Node.Decode(&d)
-			if err != nil {
-				return err
-			}
-			newRequests = append(newRequests, d)
-		case "ResourceRequirement":
-			var r ResourceRequirement
-			err := req.Node.Decode(&r)
-			if err != nil {
-				return err
-			}
-			newRequests = append(newRequests, r)
-		default:
+	for _, class := range sortedClasses(rs) {
+		req, err := decodeRequirement(class, rs[class].Node)
+		if err == errUnsupportedClass {
 			return fmt.Errorf("%s is not implemented", class)
 		}
+		if err != nil {
+			return err
+		}
+		newRequests = append(newRequests, req)
 	}
 	*reqs = newRequests
 	return nil
 }
 
-// UnmarshalYAML decodes YAML data into a Hints object.
+// UnmarshalYAML decodes YAML data into a Hints object. Hints proteus can not
+// apply are ignored with a warning.
 func (h *Hints) UnmarshalYAML(value *yaml.Node) error {
-
-	// Attempt to unmarshal as an array of Any
-	var arrayHints []interface{}
-	if err := value.Decode(&arrayHints); err == nil {
-		h.Array = arrayHints
-		return nil
+	rs, err := decodeClasses(value)
+	if err != nil {
+		return fmt.Errorf("hints must be an array or a map with class keys")
 	}
 
-	// Attempt to unmarshal as a map with class keys
-	var mapHints map[string]interface{}
-	if err := value.Decode(&mapHints); err == nil {
-		h.Map = mapHints
-		return nil
+	hints := make(Hints, 0)
+	for _, class := range sortedClasses(rs) {
+		hint, err := decodeRequirement(class, rs[class].Node)
+		if err == errUnsupportedClass {
+			log.Warnf("hint %s is not supported and is ignored", class)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		hints = append(hints, hint)
 	}
-
-	return fmt.Errorf("hints must be an array or a map with class keys")
+	*h = hints
+	return nil
 }
 
 // UnmarshalYAML decodes YAML data into a Scatter object.
@@ -318,6 +490,11 @@ func (s *ScatterMethod) UnmarshalYAML(value *yaml.Node) error {
 	return nil
 }
 
+// IsEmpty reports whether the expression was left unset.
+func (expr CWLExpression) IsEmpty() bool {
+	return expr.Kind == RawKind && expr.Raw == ""
+}
+
 // UnmarshalYAML decodes YAML data into a CWLExpression object.
 func (expr *CWLExpression) UnmarshalYAML(value *yaml.Node) error {
 	if value.Kind != yaml.ScalarNode {
@@ -396,7 +573,9 @@ func (clOutputBindingGlob *CommandlineOutputBindingGlob) UnmarshalYAML(value *ya
 	ss := make([]string, 0)
 	err = value.Decode(&ss)
 	if err == nil {
+		clOutputBindingGlob.Kind = GlobStringsKind
+		clOutputBindingGlob.Strings = ss
 		return nil
 	}
-	return nil
+	return errors.New("glob must be a string | []string | expression")
 }
@@ -1,19 +1,36 @@
 package cwl
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
 	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 func (inp *WorkflowInputs) UnmarshalYAML(value *yaml.Node) error {
-	var inputs map[string]WorkflowInputParameter
+	inputs := make(map[string]WorkflowInputParameter)
 
-	// Try to unmarshal as an Map of WorkflowInputParameter
-	if err := value.Decode(&inputs); err != nil {
-		return err
+	switch value.Kind {
+	case yaml.MappingNode:
+		if err := value.Decode(&inputs); err != nil {
+			return err
+		}
+	case yaml.SequenceNode:
+		var list []WorkflowInputParameter
+		if err := value.Decode(&list); err != nil {
+			return err
+		}
+
+		for _, input := range list {
+			if input.Id == nil {
+				return errors.New("id required for workflow inputs given as a list")
+			}
+			inputs[*input.Id] = input
+		}
+	default:
+		return errors.New("workflow inputs must be a map or a list")
 	}
 
 	*inp = inputs
@@ -39,9 +56,17 @@ func (inp *WorkflowStepInput) UnmarshalYAML(value *yaml.Node) error {
 		// Using global as a prefix for step inputs that should reference workflow inputs
 		var globalInput string = "global/" + value.Value
 		if !found {
-			formatInput.Source = &globalInput
+			formatInput.Source = Strings{globalInput}
 		} else {
-			formatInput.Source = &value.Value
+			formatInput.Source = Strings{value.Value}
+		}
+
+		*inp = formatInput
+		return nil
+	} else if value.Kind == yaml.SequenceNode {
+		// A list of sources which are merged into a single array value
+		if err := value.Decode(&formatInput.Source); err != nil {
+			return err
 		}
 
 		*inp = formatInput
@@ -55,35 +80,79 @@ func (inp *WorkflowStepInput) UnmarshalYAML(value *yaml.Node) error {
 		}
 
 		*inp = WorkflowStepInput(temp)
+
+		defaultValue, err := stepInputDefault(mappingValue(value, "default"))
+		if err != nil {
+			return err
+		}
+		inp.Default = defaultValue
 	}
 
 	return nil
 }
 
-func (inp *WorkflowStepInputs) UnmarshalYAML(value *yaml.Node) error {
-	var inputs WorkflowStepInputs
+// stepInputDefault returns the default of a step input as a parameter value,
+// scalars are kept as they are and arrays and records are JSON encoded.
+func stepInputDefault(node *yaml.Node) (*string, error) {
+	if node == nil || node.Tag == "!!null" {
+		return nil, nil
+	}
 
-	// Try to unmarshal as an WorkflowStepInput
-	var oneInput WorkflowStepInput
-	if err := value.Decode(&oneInput); err == nil {
-		var asArray []WorkflowStepInput
-		asArray = append(asArray, oneInput)
-		inputs.Array = asArray
-		inputs.Map = nil
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return &node.Value, nil
+	case yaml.SequenceNode, yaml.MappingNode:
+		if class := mappingValue(node, "class"); class != nil && (class.Value == "File" || class.Value == "Directory") {
+			return nil, fmt.Errorf("%s defaults of step inputs are not supported", class.Value)
+		}
+		var value any
+		if err := node.Decode(&value); err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("default can not be encoded: %w", err)
+		}
+		encoded := string(data)
+		return &encoded, nil
+	case yaml.AliasNode:
+		return stepInputDefault(node.Alias)
+	default:
+		return nil, errors.New("default can not be emitted")
 	}
+}
 
-	// Try to unmarshal as an Array of WorkflowStepInput
-	var arrayInputs []WorkflowStepInput
-	if err := value.Decode(&arrayInputs); err == nil {
-		inputs.Array = arrayInputs
-		inputs.Map = nil
+func (inp *WorkflowStepInputs) UnmarshalYAML(value *yaml.Node) error {
+	var inputs WorkflowStepInputs
+
+	if value.Tag == "!!null" {
+		*inp = inputs
+		return nil
 	}
 
-	// Try to unmarshal as an Map of WorkflowStepInput
-	var mapInputs map[string]WorkflowStepInput
-	if err := value.Decode(&mapInputs); err == nil {
-		inputs.Array = nil
-		inputs.Map = mapInputs
+	switch value.Kind {
+	case yaml.SequenceNode:
+		// An Array of WorkflowStepInput
+		if err := value.Decode(&inputs.Array); err != nil {
+			return err
+		}
+	case yaml.MappingNode:
+		// A Map of WorkflowStepInput keyed by id
+		inputs.Map = make(map[string]WorkflowStepInput)
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			var input WorkflowStepInput
+			if err := value.Content[i+1].Decode(&input); err != nil {
+				return fmt.Errorf("step input %s: %w", value.Content[i].Value, err)
+			}
+			inputs.Map[value.Content[i].Value] = input
+		}
+	default:
+		// A single WorkflowStepInput
+		var oneInput WorkflowStepInput
+		if err := value.Decode(&oneInput); err != nil {
+			return err
+		}
+		inputs.Array = []WorkflowStepInput{oneInput}
 	}
 
 	*inp = inputs
@@ -94,6 +163,19 @@ func (inp *WorkflowStepInputs) UnmarshalYAML(value *yaml.Node) error {
 func (steps *WorkflowSteps) UnmarshalYAML(value *yaml.Node) error {
 	var outSteps WorkflowSteps
 
+	if value.Kind == yaml.SequenceNode {
+		if err := value.Decode((*[]WorkflowStep)(&outSteps)); err != nil {
+			return err
+		}
+		for _, step := range outSteps {
+			if step.Id == "" {
+				return errors.New("id required for workflow steps given as a list")
+			}
+		}
+		*steps = outSteps
+		return nil
+	}
+
 	// Preserve the order of the nodes
 	var keys []string
 	for _, node := range value.Content {
@@ -124,10 +206,11 @@ func (steps *WorkflowSteps) UnmarshalYAML(value *yaml.Node) error {
 func (out *WorkflowStepOutputs) UnmarshalYAML(value *yaml.Node) error {
 	var outputs WorkflowStepOutputs
 
-	// Try to unmarshal as an WorkflowStepInput
-	var oneOutput []WorkflowStepOutput
-	if err := value.Decode(&oneOutput); err == nil {
-		*out = outputs
+	// Try to unmarshal as a list of objects with an id
+	var objectOutputs []WorkflowStepOutput
+	if err := value.Decode(&objectOutputs); err == nil {
+		*out = objectOutputs
+		return nil
 	}
 
 	// Try to unmarshal as a list of strings
@@ -148,16 +231,34 @@ func (out *WorkflowStepOutputs) UnmarshalYAML(value *yaml.Node) error {
 
 func (out *WorkflowOutputs) UnmarshalYAML(value *yaml.Node) error {
 
-	var tmpOutputs map[string]WorkflowOutputParameter
-	if err := value.Decode(&tmpOutputs); err != nil {
-		return err
-	}
+	tmpOutputs := make(map[string]WorkflowOutputParameter)
+
+	switch value.Kind {
+	case yaml.MappingNode:
+		if err := value.Decode(&tmpOutputs); err != nil {
+			return err
+		}
 
-	for key, output := range tmpOutputs {
-		var tmpKey = &key
-		output.Id = tmpKey
+		for key, output := range tmpOutputs {
+			var tmpKey = key
+			output.Id = &tmpKey
 
-		tmpOutputs[key] = output
+			tmpOutputs[key] = output
+		}
+	case yaml.SequenceNode:
+		var outputs []WorkflowOutputParameter
+		if err := value.Decode(&outputs); err != nil {
+			return err
+		}
+
+		for _, output := range outputs {
+			if output.Id == nil {
+				return errors.New("id required for workflow outputs given as a list")
+			}
+			tmpOutputs[*output.Id] = output
+		}
+	default:
+		return errors.New("workflow outputs must be a map or a list")
 	}
 
 	*out = tmpOutputs
@@ -166,6 +267,40 @@ func (out *WorkflowOutputs) UnmarshalYAML(value *yaml.Node) error {
 
 }
 
+// UnmarshalYAML method for LinkMergeMethod
+func (m *LinkMergeMethod) UnmarshalYAML(value *yaml.Node) error {
+	var methodName string
+	if err := value.Decode(&methodName); err != nil {
+		return err
+	}
+
+	switch methodName {
+	case string(MergeNested), string(MergeFlattened):
+		*m = LinkMergeMethod(methodName)
+	default:
+		return fmt.Errorf("unknown linkMerge method: %s", methodName)
+	}
+
+	return nil
+}
+
+// UnmarshalYAML method for PickValueMethod
+func (m *PickValueMethod) UnmarshalYAML(value *yaml.Node) error {
+	var methodName string
+	if err := value.Decode(&methodName); err != nil {
+		return err
+	}
+
+	switch methodName {
+	case string(FirstNonNull), string(TheOnlyNonNull), string(AllNonNull):
+		*m = PickValueMethod(methodName)
+	default:
+		return fmt.Errorf("unknown pickValue method: %s", methodName)
+	}
+
+	return nil
+}
+
 func (run *WorkflowCommandLineTool) UnmarshalYAML(value *yaml.Node) error {
 
 	var tmpRun WorkflowCommandLineTool
@@ -173,16 +308,13 @@ func (run *WorkflowCommandLineTool) UnmarshalYAML(value *yaml.Node) error {
 
 	var runString string
 	if err := value.Decode(&runString); err == nil {
-		if strings.Contains(runString, ".cwl") {
-			tmpContent, err := getrunContents(&runString)
-			if err != nil {
-				return err
-			}
-
-			tmpRun = *tmpContent
-		} else {
-			return fmt.Errorf("expected .cwl file reference, got %s", runString)
+		// The format of the referenced document is detected from its content
+		tmpContent, err := getrunContents(&runString)
+		if err != nil {
+			return err
 		}
+
+		tmpRun = *tmpContent
 	} else {
 
 		if err := value.Decode(&tmpCLT); err != nil {
@@ -197,48 +329,37 @@ func (run *WorkflowCommandLineTool) UnmarshalYAML(value *yaml.Node) error {
 	return nil
 }
 
-func locateFile(run *string) (*string, error) {
-
-	var localRun = localPath + *run
-	var localshareRun = localsharePath + *run
-	var homeshareRun = fmt.Sprintf(homesharePath, os.Getenv("HOME")) + *run
-
-	if _, err := os.Stat(*run); err == nil {
-		return run, nil
-	} else if _, err := os.Stat(localRun); err == nil {
-		return &localRun, nil
-	} else if _, err := os.Stat(localshareRun); err == nil {
-		return &localshareRun, nil
-	} else if _, err := os.Stat(homeshareRun); err == nil {
-		return &homeshareRun, nil
-	}
-
-	return nil, fmt.Errorf("could not find the file: %+v", *run)
-}
-
 func getrunContents(runFilePath *string) (*WorkflowCommandLineTool, error) {
-	// Expecting a file path
+	// Expecting a file path, optionally selecting a process of a packed document
+	runFile, entrypoint, _ := strings.Cut(*runFilePath, "#")
 
-	existingPath, err := locateFile(runFilePath)
+	// References are resolved when their document is loaded, otherwise they
+	// are relative to the working directory. Only tools are run by steps, so
+	// the document loaded has no run references to search for.
+	existingPath, err := locateRun("", runFile, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	def, err := os.ReadFile(*existingPath)
+	root, err := LoadEntrypoint(existingPath, entrypoint, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	var tmpData map[string]interface{}
-	err = yaml.Unmarshal(def, &tmpData)
+	class, err := DocumentClass(root)
 	if err != nil {
 		return nil, err
 	}
 
-	if tmpData["class"] == "CommandLineTool" {
+	if class == "CommandLineTool" {
 		var cliTool WorkflowCommandLineTool
 
-		err := yaml.Unmarshal(def, &cliTool)
+		err := root.Decode(&cliTool)
+		if err != nil {
+			return nil, err
+		}
+
+		cliTool.Ontology, err = LoadSchemas(cliTool.Schemas, existingPath)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,240 @@
+package cwl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	graphKey = "$graph"
+	// MainEntrypoint is the process run from a packed document by default
+	MainEntrypoint = "main"
+)
+
+// LoadEntrypoint loads a CWL document and returns the process to run. For
+// packed documents with a $graph the process is selected by entrypoint, #main
+// when empty, and the "#id" run references of its steps are resolved to the
+// other processes of the graph. Run references are searched in searchPath when
+// they are not found relative to their document.
+func LoadEntrypoint(path string, entrypoint string, searchPath []string) (*yaml.Node, error) {
+	root, err := LoadDocument(path, searchPath)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := mappingValue(root, graphKey)
+	if graph == nil {
+		if entrypoint != "" {
+			return nil, fmt.Errorf("%s has no $graph to select the entrypoint %s from", path, entrypoint)
+		}
+		normalizeIds(root)
+		return root, nil
+	}
+	if graph.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("%s: $graph must be a list of processes", path)
+	}
+
+	entries := make(map[string]*yaml.Node)
+	for _, entry := range graph.Content {
+		id := mappingValue(entry, "id")
+		if id == nil || id.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("%s: every process in $graph requires an id", path)
+		}
+		entries[graphId(id.Value)] = entry
+	}
+
+	name := graphId(entrypoint)
+	if name == "" {
+		name = MainEntrypoint
+		// A graph with a single process does not need a #main
+		if _, ok := entries[name]; !ok && len(entries) == 1 {
+			name = graphId(mappingValue(graph.Content[0], "id").Value)
+		}
+	}
+
+	process, err := graphProcess(root, entries, name, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return process, nil
+}
+
+// graphId strips the document and the leading # from a $graph reference.
+func graphId(reference string) string {
+	if _, fragment, found := strings.Cut(reference, "#"); found {
+		return fragment
+	}
+	return reference
+}
+
+func graphProcess(root *yaml.Node, entries map[string]*yaml.Node, name string, stack []string) (*yaml.Node, error) {
+	entry, ok := entries[name]
+	if !ok {
+		ids := make([]string, 0, len(entries))
+		for id := range entries {
+			ids = append(ids, "#"+id)
+		}
+		sort.Strings(ids)
+		return nil, fmt.Errorf("#%s not found in $graph, expected one of %s", name, strings.Join(ids, ", "))
+	}
+	for _, previous := range stack {
+		if previous == name {
+			return nil, fmt.Errorf("cyclic run reference: #%s -> #%s", strings.Join(stack, " -> #"), name)
+		}
+	}
+
+	process := cloneNode(entry)
+	setMappingValue(process, "id", scalarNode(name))
+	normalizeIds(process)
+
+	// Packed documents declare cwlVersion, $namespaces and $schemas once for
+	// every process in the graph
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key := root.Content[i]
+		if key.Value == graphKey || mappingValue(process, key.Value) != nil {
+			continue
+		}
+		process.Content = append(process.Content, cloneNode(key), cloneNode(root.Content[i+1]))
+	}
+
+	err := resolveGraphRuns(process, processNode, root, entries, append(stack, name))
+	if err != nil {
+		return nil, err
+	}
+	return process, nil
+}
+
+// resolveGraphRuns replaces the "#id" run references of steps with the
+// processes of the graph.
+func resolveGraphRuns(node *yaml.Node, context nodeContext, root *yaml.Node, entries map[string]*yaml.Node, stack []string) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := resolveGraphRuns(child, itemContext(context), root, entries, stack); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if isRunReference(context, key, value) && strings.HasPrefix(value.Value, "#") {
+				process, err := graphProcess(root, entries, graphId(value.Value), stack)
+				if err != nil {
+					return err
+				}
+				node.Content[i+1] = process
+				continue
+			}
+			if err := resolveGraphRuns(value, fieldContext(context, key.Value), root, entries, stack); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func cloneNode(node *yaml.Node) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+	clone := *node
+	clone.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		clone.Content[i] = cloneNode(child)
+	}
+	return &clone
+}
+
+// normalizeIds rewrites the "#process/name" ids and sources of packed
+// documents into the local names used by the other documents. Parameter and
+// step ids keep their last segment, sources drop the id of the process
+// declaring them, so "#main/step/out" becomes "step/out".
+func normalizeIds(process *yaml.Node) {
+	owner := ""
+	if id := mappingValue(process, "id"); id != nil {
+		owner = strings.TrimPrefix(id.Value, "#")
+	}
+
+	normalizeParameters(mappingValue(process, "inputs"), func(*yaml.Node) {})
+	normalizeParameters(mappingValue(process, "outputs"), func(output *yaml.Node) {
+		normalizeSources(mappingValue(output, "outputSource"), owner)
+	})
+	normalizeParameters(mappingValue(process, "steps"), func(step *yaml.Node) {
+		normalizeParameters(mappingValue(step, "in"), func(input *yaml.Node) {
+			if input.Kind == yaml.ScalarNode {
+				normalizeSources(input, owner)
+				return
+			}
+			normalizeSources(mappingValue(input, "source"), owner)
+		})
+		normalizeParameters(mappingValue(step, "out"), func(*yaml.Node) {})
+		if scatter := mappingValue(step, "scatter"); scatter != nil && scatter.Kind == yaml.ScalarNode {
+			scatter.Value = localId(scatter.Value)
+		} else {
+			normalizeParameters(scatter, func(*yaml.Node) {})
+		}
+		if run := mappingValue(step, "run"); run != nil && run.Kind == yaml.MappingNode {
+			normalizeIds(run)
+		}
+	})
+}
+
+// normalizeParameters shortens the ids of a list or a map of parameters,
+// steps or step inputs and outputs, and calls normalize for each of them.
+func normalizeParameters(parameters *yaml.Node, normalize func(*yaml.Node)) {
+	if parameters == nil {
+		return
+	}
+	switch parameters.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(parameters.Content); i += 2 {
+			parameters.Content[i].Value = localId(parameters.Content[i].Value)
+			normalize(parameters.Content[i+1])
+		}
+	case yaml.SequenceNode:
+		for _, parameter := range parameters.Content {
+			if parameter.Kind == yaml.ScalarNode {
+				parameter.Value = localId(parameter.Value)
+				continue
+			}
+			if id := mappingValue(parameter, "id"); id != nil {
+				id.Value = localId(id.Value)
+			}
+			normalize(parameter)
+		}
+	}
+}
+
+// normalizeSources strips the leading # and the id of the owning process from
+// a source or a list of sources.
+func normalizeSources(sources *yaml.Node, owner string) {
+	if sources == nil {
+		return
+	}
+	if sources.Kind == yaml.SequenceNode {
+		for _, source := range sources.Content {
+			normalizeSources(source, owner)
+		}
+		return
+	}
+	if sources.Kind != yaml.ScalarNode || !strings.HasPrefix(sources.Value, "#") {
+		return
+	}
+	sources.Value = strings.TrimPrefix(strings.TrimPrefix(sources.Value, "#"), owner+"/")
+}
+
+// localId returns the last segment of a "#process/name" id, other ids are
+// kept as they are.
+func localId(id string) string {
+	if !strings.HasPrefix(id, "#") {
+		return id
+	}
+	id = strings.TrimPrefix(id, "#")
+	if idx := strings.LastIndex(id, "/"); idx >= 0 {
+		return id[idx+1:]
+	}
+	return id
+}
@@ -0,0 +1,402 @@
+package cwl
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const namespacesKey = "$namespaces"
+
+// LoadDocument reads a CWL document and runs the preprocessing required
+// before it can be decoded into a CommandLineTool or a Workflow. Run
+// references not found relative to their document are searched in searchPath.
+func LoadDocument(path string, searchPath []string) (*yaml.Node, error) {
+	loader := documentLoader{searchPath: searchPath}
+	root, err := loader.load(path, processNode)
+	if err != nil {
+		return nil, err
+	}
+
+	err = expandNamespaces(root)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	err = upgradeDocument(root, path)
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// documentLoader resolves the $import, $include and $mixin directives of a
+// document, relative to the file which contains them.
+type documentLoader struct {
+	// stack of the files being loaded, used to detect cycles
+	stack []string
+	// searchPath holds the directories searched for run documents which are
+	// not found relative to the document referencing them
+	searchPath []string
+}
+
+// nodeContext tells where a node is in a document, run fields are only
+// references to documents in workflow steps.
+type nodeContext int
+
+const (
+	processNode nodeContext = iota
+	stepsNode
+	stepNode
+)
+
+// itemContext returns the context of the items of a sequence.
+func itemContext(context nodeContext) nodeContext {
+	if context == stepsNode {
+		return stepNode
+	}
+	return processNode
+}
+
+// fieldContext returns the context of the value of a field of a mapping.
+func fieldContext(context nodeContext, key string) nodeContext {
+	switch {
+	case context == stepsNode:
+		return stepNode
+	case key == "steps":
+		return stepsNode
+	}
+	return processNode
+}
+
+// isRunReference reports whether a field of a mapping references the document
+// run by a step.
+func isRunReference(context nodeContext, key *yaml.Node, value *yaml.Node) bool {
+	return context == stepNode && key.Value == "run" && value.Kind == yaml.ScalarNode
+}
+
+func (l *documentLoader) load(path string, context nodeContext) (*yaml.Node, error) {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for i, loading := range l.stack {
+		if loading == absolute {
+			cycle := append(append([]string{}, l.stack[i:]...), absolute)
+			return nil, fmt.Errorf("cyclic $import: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	data, err := readDocument(path)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := parseDocument(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	l.stack = append(l.stack, absolute)
+	defer func() { l.stack = l.stack[:len(l.stack)-1] }()
+
+	err = l.resolve(root, path, context)
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// directive returns the target of a mapping which only holds key.
+func directive(node *yaml.Node, key string) (string, bool, error) {
+	target := mappingValue(node, key)
+	if target == nil {
+		return "", false, nil
+	}
+	if len(node.Content) != 2 {
+		return "", true, fmt.Errorf("%s must be the only field of its object", key)
+	}
+	if target.Kind != yaml.ScalarNode {
+		return "", true, fmt.Errorf("%s must reference a file", key)
+	}
+	return target.Value, true, nil
+}
+
+func (l *documentLoader) resolve(node *yaml.Node, path string, context nodeContext) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if err := l.resolve(item, path, itemContext(context)); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		if target, ok, err := directive(node, "$import"); ok {
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			imported, err := l.load(resolveDocumentPath(path, target), context)
+			if err != nil {
+				return fmt.Errorf("%s: $import %s: %w", path, target, err)
+			}
+			*node = *imported
+			return nil
+		}
+
+		if target, ok, err := directive(node, "$include"); ok {
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			data, err := os.ReadFile(resolveDocumentPath(path, target))
+			if err != nil {
+				return fmt.Errorf("%s: $include %s: %w", path, target, err)
+			}
+			*node = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: string(data)}
+			return nil
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if isRunReference(context, key, value) {
+				if err := l.resolveRun(value, path); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := l.resolve(value, path, fieldContext(context, key.Value)); err != nil {
+				return err
+			}
+		}
+
+		return l.mixin(node, path, context)
+	}
+	return nil
+}
+
+// mixin merges the fields of the object referenced by $mixin into node, the
+// fields of node take precedence.
+func (l *documentLoader) mixin(node *yaml.Node, path string, context nodeContext) error {
+	target := mappingValue(node, "$mixin")
+	if target == nil {
+		return nil
+	}
+	if target.Kind != yaml.ScalarNode {
+		return fmt.Errorf("%s: $mixin must reference a file", path)
+	}
+
+	mixed, err := l.load(resolveDocumentPath(path, target.Value), context)
+	if err != nil {
+		return fmt.Errorf("%s: $mixin %s: %w", path, target.Value, err)
+	}
+	if mixed.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: $mixin %s must be an object", path, target.Value)
+	}
+
+	content := make([]*yaml.Node, 0, len(node.Content)+len(mixed.Content))
+	for i := 0; i+1 < len(mixed.Content); i += 2 {
+		if mappingValue(node, mixed.Content[i].Value) == nil {
+			content = append(content, mixed.Content[i], mixed.Content[i+1])
+		}
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != "$mixin" {
+			content = append(content, node.Content[i], node.Content[i+1])
+		}
+	}
+	node.Content = content
+	return nil
+}
+
+// DocumentClass returns the class of a loaded document.
+func DocumentClass(root *yaml.Node) (string, error) {
+	node := mappingValue(root, "class")
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return "", errors.New("<class> expected")
+	}
+	return node.Value, nil
+}
+
+// shareDirectories returns the shared commonwl directories searched for
+// documents and ontologies.
+func shareDirectories() []string {
+	return []string{
+		localPath,
+		localsharePath,
+		fmt.Sprintf(homesharePath, os.Getenv("HOME")),
+	}
+}
+
+// mappingValue returns the value of key in a mapping node.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func scalarNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+// setMappingValue sets the value of key in a mapping node.
+func setMappingValue(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = value
+			return
+		}
+	}
+	node.Content = append([]*yaml.Node{scalarNode(key), value}, node.Content...)
+}
+
+// DocumentNamespaces returns the $namespaces declared by a document.
+func DocumentNamespaces(root *yaml.Node) (map[string]string, error) {
+	namespaces := make(map[string]string)
+	node := mappingValue(root, namespacesKey)
+	if node == nil {
+		return namespaces, nil
+	}
+	if err := node.Decode(&namespaces); err != nil {
+		return nil, fmt.Errorf("%s must map prefixes to namespaces", namespacesKey)
+	}
+	return namespaces, nil
+}
+
+// ExpandIRI expands a prefixed name whose prefix is declared in namespaces,
+// anything else is returned unchanged.
+func ExpandIRI(value string, namespaces map[string]string) string {
+	prefix, name, found := strings.Cut(value, ":")
+	if !found || strings.HasPrefix(name, "//") {
+		return value
+	}
+	namespace, ok := namespaces[prefix]
+	if !ok {
+		return value
+	}
+	return namespace + name
+}
+
+// expandNamespaces expands the prefixed names of formats, classes and
+// extension fields with the $namespaces of the document.
+func expandNamespaces(root *yaml.Node) error {
+	namespaces, err := DocumentNamespaces(root)
+	if err != nil {
+		return err
+	}
+	if len(namespaces) == 0 {
+		return nil
+	}
+	expandNode(root, namespaces)
+	return nil
+}
+
+func expandScalar(node *yaml.Node, namespaces map[string]string) {
+	if node.Kind != yaml.ScalarNode || strings.Contains(node.Value, "$(") || strings.Contains(node.Value, "${") {
+		return
+	}
+	node.Value = ExpandIRI(node.Value, namespaces)
+}
+
+func expandNode(node *yaml.Node, namespaces map[string]string) {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			expandNode(child, namespaces)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == namespacesKey {
+				continue
+			}
+
+			// Extension fields and classes used as keys of hints or requirements
+			expandScalar(key, namespaces)
+
+			switch key.Value {
+			case "format", "class":
+				expandScalar(value, namespaces)
+				if value.Kind == yaml.SequenceNode {
+					for _, item := range value.Content {
+						expandScalar(item, namespaces)
+					}
+				}
+			}
+			expandNode(value, namespaces)
+		}
+	}
+}
+
+// resolveRun replaces a run reference with the location of the document it
+// refers to, references within a $graph are left to the graph.
+func (l *documentLoader) resolveRun(node *yaml.Node, path string) error {
+	reference, entrypoint, found := strings.Cut(node.Value, "#")
+	if reference == "" {
+		return nil
+	}
+
+	location, err := locateRun(path, reference, l.searchPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if found {
+		location += "#" + entrypoint
+	}
+	node.Value = location
+	return nil
+}
+
+// locateRun finds the document referenced by a run field, relative to the
+// referencing document, then in searchPath and the shared commonwl
+// directories.
+func locateRun(base string, reference string, searchPath []string) (string, error) {
+	if u, err := url.Parse(reference); err == nil && u.Scheme != "" {
+		if u.Scheme != "file" {
+			return "", fmt.Errorf("only local run documents are supported, got %s", reference)
+		}
+		reference = u.Path
+	}
+
+	if filepath.IsAbs(reference) {
+		if _, err := os.Stat(reference); err != nil {
+			return "", fmt.Errorf("could not find the file: %+v", reference)
+		}
+		return reference, nil
+	}
+
+	directories := make([]string, 0, len(searchPath)+3)
+	directories = append(directories, searchPath...)
+	directories = append(directories, shareDirectories()...)
+
+	candidates := []string{resolveDocumentPath(base, reference)}
+	for _, dir := range directories {
+		candidates = append(candidates, filepath.Join(dir, reference))
+	}
+
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return filepath.Abs(candidate)
+		}
+	}
+	return "", fmt.Errorf("could not find the file: %+v, searched %s", reference, strings.Join(candidates, ", "))
+}
+
+// resolveDocumentPath resolves a path referenced by a document relative to
+// the directory of the document.
+func resolveDocumentPath(base string, path string) string {
+	path = strings.TrimPrefix(path, "file://")
+	if filepath.IsAbs(path) || base == "" {
+		return path
+	}
+	return filepath.Join(filepath.Dir(base), path)
+}
@@ -0,0 +1,340 @@
+package cwl
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	log "github.com/sirupsen/logrus"
+)
+
+// azureBlobHost is the host suffix of azure blob storage accounts, whose
+// https locations are read as azure artifacts rather than plain http.
+const azureBlobHost = ".blob.core.windows.net"
+
+// ParseLocationURI returns the artifact location of a File or Directory
+// location URI. Local paths and file:// URIs have no artifact location, in
+// which case false is returned.
+func ParseLocationURI(name string, location string) (*FileLocationData, bool, error) {
+	if !strings.Contains(location, "://") {
+		return nil, false, nil
+	}
+	uri, err := url.Parse(location)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid location %s: %w", location, err)
+	}
+
+	data := &FileLocationData{Name: name}
+	key := strings.TrimPrefix(uri.Path, "/")
+
+	switch uri.Scheme {
+	case "file":
+		return nil, false, nil
+	case "s3":
+		if uri.Host == "" {
+			return nil, false, fmt.Errorf("location %s must be of the form s3://bucket[/key]", location)
+		}
+		data.Type = S3Kind
+		data.S3 = &v1alpha1.S3Artifact{
+			S3Bucket: v1alpha1.S3Bucket{Bucket: uri.Host},
+			Key:      key,
+		}
+	case "gs":
+		if uri.Host == "" {
+			return nil, false, fmt.Errorf("location %s must be of the form gs://bucket[/key]", location)
+		}
+		data.Type = GCSKind
+		data.GCS = &v1alpha1.GCSArtifact{
+			GCSBucket: v1alpha1.GCSBucket{Bucket: uri.Host},
+			Key:       key,
+		}
+	case "oss":
+		if uri.Host == "" {
+			return nil, false, fmt.Errorf("location %s must be of the form oss://bucket[/key]", location)
+		}
+		data.Type = OSSKind
+		data.OSS = &v1alpha1.OSSArtifact{
+			OSSBucket: v1alpha1.OSSBucket{Bucket: uri.Host},
+			Key:       key,
+		}
+	case "hdfs":
+		if uri.Host == "" || uri.Path == "" {
+			return nil, false, fmt.Errorf("location %s must be of the form hdfs://host:port/path", location)
+		}
+		data.Type = HDFSKind
+		data.HDFS = &v1alpha1.HDFSArtifact{
+			HDFSConfig: v1alpha1.HDFSConfig{Addresses: []string{uri.Host}},
+			Path:       uri.Path,
+		}
+	case "http", "https":
+		if uri.Scheme == "https" && strings.HasSuffix(uri.Host, azureBlobHost) {
+			container, blob, _ := strings.Cut(key, "/")
+			if container == "" || blob == "" {
+				return nil, false, fmt.Errorf("location %s must be of the form https://account%s/container/blob", location, azureBlobHost)
+			}
+			data.Type = AzureKind
+			data.Azure = &v1alpha1.AzureArtifact{
+				AzureBlobContainer: v1alpha1.AzureBlobContainer{
+					Endpoint:  fmt.Sprintf("https://%s", uri.Host),
+					Container: container,
+				},
+				Blob: blob,
+			}
+			break
+		}
+		data.Type = HTTPKind
+		data.HTTP = &v1alpha1.HTTPArtifact{URL: location}
+	default:
+		return nil, false, fmt.Errorf("unsupported location scheme %s in %s", uri.Scheme, location)
+	}
+	return data, true, nil
+}
+
+// InferInputLocations adds the artifact locations of File and Directory job
+// inputs whose location is a remote URI, and stages File literals, which only
+// hold their contents, as raw artifacts. Entries of the locations file take
+// precedence, an entry of the same type only needs to provide credentials or
+// endpoint overrides and is completed from the URI.
+func InferInputLocations(inputs map[string]CWLInputEntry, locations FileLocations) (FileLocations, error) {
+	for key, input := range inputs {
+		var location *string
+		switch input.Kind {
+		case CWLFileKind:
+			location = input.FileData.Location
+		case CWLDirectoryKind:
+			location = input.DirectoryData.Location
+		}
+
+		var inferred *FileLocationData
+		var ok bool
+		switch {
+		case input.Kind == CWLFileKind && IsFileLiteral(input.FileData):
+			inferred, ok = &FileLocationData{
+				Name: key,
+				Type: RawLocationKind,
+				Raw:  &v1alpha1.RawArtifact{Data: *input.FileData.Contents},
+			}, true
+		case location != nil:
+			var err error
+			inferred, ok, err = ParseLocationURI(key, *location)
+			if err != nil {
+				return locations, fmt.Errorf("%s: %w", key, err)
+			}
+			if !ok && locations.ArtifactRepository != nil && isRepositoryKey(*location) {
+				keyOnly := KeyOnlyLocation(key, *location)
+				inferred, ok = &keyOnly, true
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		if locations.Inputs == nil {
+			locations.Inputs = make(map[string]FileLocationData)
+		}
+		explicit, ok := locations.Inputs[key]
+		if !ok {
+			locations.Inputs[key] = *inferred
+			continue
+		}
+		if explicit.Type != inferred.Type {
+			log.Warnf("location of %s is %s in the locations file, ignoring the %s location of the job", key, explicit.Type, inferred.Type)
+			continue
+		}
+		locations.Inputs[key] = mergeLocation(explicit, *inferred)
+	}
+	return locations, nil
+}
+
+// IsFileLiteral reports whether a File is given by its contents only.
+func IsFileLiteral(file *CWLFile) bool {
+	return file.Contents != nil && file.Location == nil && file.Path == nil
+}
+
+// isRepositoryKey reports whether a location is a key in the artifact
+// repository, which are relative locations without a scheme.
+func isRepositoryKey(location string) bool {
+	return !strings.Contains(location, "://") && !strings.HasPrefix(location, "/")
+}
+
+// KeyOnlyLocation returns the location of a key in the artifact repository,
+// argo completes it with the bucket and credentials of the repository.
+func KeyOnlyLocation(name string, key string) FileLocationData {
+	return FileLocationData{
+		Name: name,
+		Type: S3Kind,
+		S3:   &v1alpha1.S3Artifact{Key: key},
+	}
+}
+
+// isKeyOnly reports whether a location only holds a key of the artifact
+// repository.
+func (f FileLocationData) isKeyOnly() bool {
+	return f.Type == S3Kind && f.S3.Bucket == "" && f.S3.Endpoint == "" && f.S3.Key != ""
+}
+
+// mergeLocation completes an explicit location with the fields parsed from a
+// location URI, fields set explicitly are kept.
+func mergeLocation(explicit FileLocationData, inferred FileLocationData) FileLocationData {
+	if explicit.Name == "" {
+		explicit.Name = inferred.Name
+	}
+	switch explicit.Type {
+	case S3Kind:
+		setDefault(&explicit.S3.Bucket, inferred.S3.Bucket)
+		setDefault(&explicit.S3.Key, inferred.S3.Key)
+	case GCSKind:
+		setDefault(&explicit.GCS.Bucket, inferred.GCS.Bucket)
+		setDefault(&explicit.GCS.Key, inferred.GCS.Key)
+	case OSSKind:
+		setDefault(&explicit.OSS.Bucket, inferred.OSS.Bucket)
+		setDefault(&explicit.OSS.Key, inferred.OSS.Key)
+	case HDFSKind:
+		if len(explicit.HDFS.Addresses) == 0 {
+			explicit.HDFS.Addresses = inferred.HDFS.Addresses
+		}
+		setDefault(&explicit.HDFS.Path, inferred.HDFS.Path)
+	case AzureKind:
+		setDefault(&explicit.Azure.Endpoint, inferred.Azure.Endpoint)
+		setDefault(&explicit.Azure.Container, inferred.Azure.Container)
+		setDefault(&explicit.Azure.Blob, inferred.Azure.Blob)
+	case HTTPKind:
+		setDefault(&explicit.HTTP.URL, inferred.HTTP.URL)
+	case RawLocationKind:
+		setDefault(&explicit.Raw.Data, inferred.Raw.Data)
+	}
+	return explicit
+}
+
+func setDefault(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+// Validate checks the fields each artifact driver requires are set. Outputs
+// are written by argo, so read only drivers are rejected for them.
+func (f FileLocationData) Validate(output bool) error {
+	var missing []string
+	require := func(field string, value string) {
+		if value == "" {
+			missing = append(missing, field)
+		}
+	}
+
+	switch f.Type {
+	case HTTPKind:
+		require("url", f.HTTP.URL)
+	case S3Kind:
+		require("bucket", f.S3.Bucket)
+		require("key", f.S3.Key)
+	case GCSKind:
+		require("bucket", f.GCS.Bucket)
+		require("key", f.GCS.Key)
+	case OSSKind:
+		require("bucket", f.OSS.Bucket)
+		require("key", f.OSS.Key)
+	case HDFSKind:
+		if len(f.HDFS.Addresses) == 0 {
+			missing = append(missing, "addresses")
+		}
+		require("path", f.HDFS.Path)
+	case AzureKind:
+		require("endpoint", f.Azure.Endpoint)
+		require("container", f.Azure.Container)
+		require("blob", f.Azure.Blob)
+	case GITKind:
+		require("repo", f.Git.Repo)
+	case ArtifactoryKind:
+		require("url", f.Artifactory.URL)
+	case RawLocationKind:
+		require("data", f.Raw.Data)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s location requires %s", f.Type, strings.Join(missing, ", "))
+	}
+
+	if output && (f.Type == GITKind || f.Type == RawLocationKind) {
+		return fmt.Errorf("%s locations can not be written to", f.Type)
+	}
+	return nil
+}
+
+// Validate checks every input and output location of the locations file.
+// Key-only locations are valid when an artifact repository is used.
+func (f FileLocations) Validate() error {
+	validate := func(location FileLocationData, output bool) error {
+		if f.ArtifactRepository != nil && location.isKeyOnly() {
+			return nil
+		}
+		return location.Validate(output)
+	}
+	for _, key := range sortedLocationKeys(f.Inputs) {
+		if err := validate(f.Inputs[key], false); err != nil {
+			return fmt.Errorf("input %s: %w", key, err)
+		}
+	}
+	for _, key := range sortedLocationKeys(f.Outputs) {
+		if err := validate(f.Outputs[key], true); err != nil {
+			return fmt.Errorf("output %s: %w", key, err)
+		}
+		if err := f.Outputs[key].ArtifactOptions.Validate(); err != nil {
+			return fmt.Errorf("output %s: %w", key, err)
+		}
+	}
+	if err := f.Artifacts.Validate(); err != nil {
+		return fmt.Errorf("artifacts: %w", err)
+	}
+	return nil
+}
+
+// Validate checks the archive and garbage collection strategies are known and
+// the compression level is a gzip level of a tar archive.
+func (a ArtifactOptions) Validate() error {
+	switch a.Archive {
+	case "", ArchiveNone, ArchiveTar, ArchiveZip:
+	default:
+		return fmt.Errorf("unknown archive %s, expected %s, %s or %s", a.Archive, ArchiveNone, ArchiveTar, ArchiveZip)
+	}
+
+	if a.CompressionLevel != nil {
+		if *a.CompressionLevel < 0 || *a.CompressionLevel > 9 {
+			return fmt.Errorf("compressionLevel %d is not between 0 and 9", *a.CompressionLevel)
+		}
+		if a.Archive != "" && a.Archive != ArchiveTar {
+			return fmt.Errorf("compressionLevel only applies to %s archives", ArchiveTar)
+		}
+	}
+
+	switch a.ArtifactGC {
+	case v1alpha1.ArtifactGCStrategyUndefined, v1alpha1.ArtifactGCOnWorkflowCompletion, v1alpha1.ArtifactGCOnWorkflowDeletion, v1alpha1.ArtifactGCNever:
+	default:
+		return fmt.Errorf("unknown artifactGC strategy %s", a.ArtifactGC)
+	}
+	return nil
+}
+
+// Override returns the options with the fields set in other replacing them.
+func (a ArtifactOptions) Override(other ArtifactOptions) ArtifactOptions {
+	if other.Archive != "" {
+		a.Archive = other.Archive
+	}
+	if other.CompressionLevel != nil {
+		a.CompressionLevel = other.CompressionLevel
+	}
+	if other.ArtifactGC != "" {
+		a.ArtifactGC = other.ArtifactGC
+	}
+	return a
+}
+
+func sortedLocationKeys(locations map[string]FileLocationData) []string {
+	keys := make([]string, 0, len(locations))
+	for key := range locations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
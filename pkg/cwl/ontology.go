@@ -0,0 +1,131 @@
+package cwl
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	rdfNamespace  = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	rdfsNamespace = "http://www.w3.org/2000/01/rdf-schema#"
+)
+
+// Ontology holds the subclass relations of the formats declared in $schemas.
+type Ontology struct {
+	SuperClasses map[string][]string
+}
+
+// IsSubClassOf reports whether format is class or one of its subclasses.
+// Without an ontology only identical formats are compatible.
+func (o *Ontology) IsSubClassOf(format string, class string) bool {
+	seen := make(map[string]bool)
+	pending := []string{format}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+		if current == class {
+			return true
+		}
+		if seen[current] || o == nil {
+			continue
+		}
+		seen[current] = true
+		pending = append(pending, o.SuperClasses[current]...)
+	}
+	return false
+}
+
+// LoadSchemas loads the ontologies listed in $schemas. They are read from
+// disk, relative to the document or, for remote IRIs, by their file name in
+// the directory of the document or the shared commonwl directories.
+// Ontologies which can not be found are skipped with a warning.
+func LoadSchemas(schemas []string, base string) (*Ontology, error) {
+	ontology := &Ontology{SuperClasses: make(map[string][]string)}
+	for _, schema := range schemas {
+		location, ok := locateSchema(schema, base)
+		if !ok {
+			log.Warnf("ontology %s could not be found, formats are only compared by name", schema)
+			continue
+		}
+		err := ontology.loadRDFXML(location)
+		if err != nil {
+			return nil, fmt.Errorf("loading ontology %s: %w", schema, err)
+		}
+	}
+	return ontology, nil
+}
+
+func locateSchema(schema string, base string) (string, bool) {
+	candidates := make([]string, 0)
+	if u, err := url.Parse(schema); err == nil && u.Scheme != "" && u.Scheme != "file" {
+		name := path.Base(u.Path)
+		candidates = append(candidates, resolveDocumentPath(base, name))
+		for _, dir := range shareDirectories() {
+			candidates = append(candidates, path.Join(dir, "schemas", name), path.Join(dir, name))
+		}
+	} else {
+		candidates = append(candidates, resolveDocumentPath(base, schema))
+	}
+
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// loadRDFXML reads the rdfs:subClassOf relations of an RDF/XML ontology.
+func (o *Ontology) loadRDFXML(location string) error {
+	file, err := os.Open(location)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := xml.NewDecoder(file)
+	subjects := make([]string, 0)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			about := xmlAttribute(element, rdfNamespace, "about")
+			if element.Name.Space == rdfsNamespace && element.Name.Local == "subClassOf" {
+				resource := xmlAttribute(element, rdfNamespace, "resource")
+				if resource != "" && len(subjects) > 0 && subjects[len(subjects)-1] != "" {
+					subject := subjects[len(subjects)-1]
+					o.SuperClasses[subject] = append(o.SuperClasses[subject], resource)
+				}
+			}
+			subjects = append(subjects, strings.TrimSpace(about))
+		case xml.EndElement:
+			if len(subjects) > 0 {
+				subjects = subjects[:len(subjects)-1]
+			}
+		}
+	}
+}
+
+func xmlAttribute(element xml.StartElement, space string, local string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Space == space && attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
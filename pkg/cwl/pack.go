@@ -0,0 +1,233 @@
+package cwl
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// packer collects the processes of a document and the documents referenced
+// by its steps into a single $graph.
+type packer struct {
+	// graph ids of the documents already packed, by location
+	ids        map[string]string
+	used       map[string]bool
+	graph      []*yaml.Node
+	version    string
+	namespaces map[string]string
+	schemas    []string
+	searchPath []string
+	// absolute directory the packed document is written to
+	outputDir string
+}
+
+// Pack bundles the document at path and every process referenced by the run
+// field of its steps into a packed $graph document, with the document itself
+// as #main and the steps referring to the other processes by their graph id.
+// Run references not found relative to their document are searched in
+// searchPath. Local $schemas are referenced relative to outputDir, where the
+// packed document is written, or to the directory of path when it is empty.
+func Pack(path string, outputDir string, searchPath []string) ([]byte, error) {
+	if outputDir == "" {
+		outputDir = filepath.Dir(path)
+	}
+	outputDir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	p := packer{
+		ids:        make(map[string]string),
+		used:       make(map[string]bool),
+		namespaces: make(map[string]string),
+		searchPath: searchPath,
+		outputDir:  outputDir,
+	}
+
+	_, err = p.add(path, "", MainEntrypoint)
+	if err != nil {
+		return nil, err
+	}
+
+	packed := &yaml.Node{Kind: yaml.MappingNode}
+	if p.version != "" {
+		packed.Content = append(packed.Content, scalarNode("cwlVersion"), scalarNode(p.version))
+	}
+	if len(p.namespaces) > 0 {
+		namespaces := &yaml.Node{}
+		if err := namespaces.Encode(p.namespaces); err != nil {
+			return nil, err
+		}
+		packed.Content = append(packed.Content, scalarNode(namespacesKey), namespaces)
+	}
+	if len(p.schemas) > 0 {
+		schemas := &yaml.Node{}
+		if err := schemas.Encode(p.schemas); err != nil {
+			return nil, err
+		}
+		packed.Content = append(packed.Content, scalarNode("$schemas"), schemas)
+	}
+	packed.Content = append(packed.Content, scalarNode(graphKey), &yaml.Node{Kind: yaml.SequenceNode, Content: p.graph})
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(packed); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// graphName returns an unused graph id derived from the name of a document.
+func (p *packer) graphName(name string) string {
+	name = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	id := name
+	for i := 2; p.used[id]; i++ {
+		id = fmt.Sprintf("%s-%d", name, i)
+	}
+	p.used[id] = true
+	return id
+}
+
+// add packs the process of a document and returns its graph id.
+func (p *packer) add(path string, entrypoint string, name string) (string, error) {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	location := absolute + "#" + entrypoint
+	if id, ok := p.ids[location]; ok {
+		return id, nil
+	}
+
+	process, err := LoadEntrypoint(path, entrypoint, p.searchPath)
+	if err != nil {
+		return "", err
+	}
+
+	id := p.graphName(name)
+	p.ids[location] = id
+
+	err = p.hoist(process, path)
+	if err != nil {
+		return "", err
+	}
+
+	err = p.packRuns(process, path, processNode)
+	if err != nil {
+		return "", err
+	}
+
+	setMappingValue(process, "id", scalarNode("#"+id))
+	p.graph = append(p.graph, process)
+	return id, nil
+}
+
+// hoist moves the fields shared by every process of a packed document to its
+// top level.
+func (p *packer) hoist(process *yaml.Node, path string) error {
+	content := make([]*yaml.Node, 0, len(process.Content))
+	for i := 0; i+1 < len(process.Content); i += 2 {
+		key, value := process.Content[i], process.Content[i+1]
+		switch key.Value {
+		case "cwlVersion":
+			if p.version != "" && p.version != value.Value {
+				return fmt.Errorf("%s: cwlVersion %s differs from %s, documents of different versions can not be packed", path, value.Value, p.version)
+			}
+			p.version = value.Value
+		case namespacesKey:
+			namespaces := make(map[string]string)
+			if err := value.Decode(&namespaces); err != nil {
+				return fmt.Errorf("%s: %s must map prefixes to namespaces", path, namespacesKey)
+			}
+			for prefix, namespace := range namespaces {
+				if existing, ok := p.namespaces[prefix]; ok && existing != namespace {
+					return fmt.Errorf("%s: namespace %s is declared as both %s and %s", path, prefix, existing, namespace)
+				}
+				p.namespaces[prefix] = namespace
+			}
+		case "$schemas":
+			var schemas Strings
+			if err := value.Decode(&schemas); err != nil {
+				return fmt.Errorf("%s: $schemas must be a list of ontologies", path)
+			}
+			for _, schema := range schemas {
+				// Local ontologies are referenced from where the packed document is written
+				if !strings.Contains(schema, "://") {
+					absolute, err := filepath.Abs(resolveDocumentPath(path, schema))
+					if err != nil {
+						return err
+					}
+					relative, err := filepath.Rel(p.outputDir, absolute)
+					if err != nil {
+						return fmt.Errorf("%s: $schemas %s can not be referenced from %s: %w", path, schema, p.outputDir, err)
+					}
+					schema = filepath.ToSlash(relative)
+				}
+				p.schemas = appendUnique(p.schemas, schema)
+			}
+		default:
+			content = append(content, key, value)
+		}
+	}
+	process.Content = content
+	return nil
+}
+
+// packRuns packs the documents referenced by run fields and replaces the
+// references with their graph ids.
+func (p *packer) packRuns(node *yaml.Node, path string, context nodeContext) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := p.packRuns(child, path, itemContext(context)); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if isRunReference(context, key, value) {
+				runFile, entrypoint, _ := strings.Cut(value.Value, "#")
+				if runFile == "" {
+					return fmt.Errorf("%s: run reference %s has no $graph to resolve from", path, value.Value)
+				}
+
+				location, err := locateRun(path, runFile, p.searchPath)
+				if err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+
+				name := location
+				if entrypoint != "" {
+					name = entrypoint
+				}
+				id, err := p.add(location, entrypoint, name)
+				if err != nil {
+					return err
+				}
+				value.Value = "#" + id
+				continue
+			}
+			if err := p.packRuns(value, path, fieldContext(context, key.Value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,48 @@
+package cwl
+
+// InheritRequirements resolves the requirements in effect for a process from
+// those of the levels enclosing it, outermost first. A requirement defined
+// closer to the process replaces the inherited requirement of the same class.
+func InheritRequirements(levels ...Requirements) Requirements {
+	position := make(map[string]int)
+	effective := make(Requirements, 0)
+
+	for _, level := range levels {
+		for _, req := range level {
+			if idx, ok := position[req.getClass()]; ok {
+				effective[idx] = req
+				continue
+			}
+			position[req.getClass()] = len(effective)
+			effective = append(effective, req)
+		}
+	}
+	return effective
+}
+
+// InheritHints resolves the hints in effect for a process like
+// InheritRequirements, the closest hint of a class wins.
+func InheritHints(levels ...Hints) Hints {
+	reqs := make([]Requirements, 0)
+	for _, level := range levels {
+		reqs = append(reqs, Requirements(level))
+	}
+	return Hints(InheritRequirements(reqs...))
+}
+
+// ApplyHints adds the hints whose class is not overridden by a requirement.
+func ApplyHints(reqs Requirements, hints Hints) Requirements {
+	applied := append(Requirements(nil), reqs...)
+	for _, hint := range hints {
+		overridden := false
+		for _, req := range reqs {
+			if req.getClass() == hint.getClass() {
+				overridden = true
+			}
+		}
+		if !overridden {
+			applied = append(applied, hint)
+		}
+	}
+	return applied
+}
@@ -3,6 +3,7 @@ package cwl
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 func errorNilRequirements(id *string) error {
@@ -19,8 +20,16 @@ func errorDockerRequirement(id *string) error {
 	return errors.New("DockerRequirement must be present in all Argo CWL definitions")
 }
 
-func isAllFiles(tys []CWLType) bool {
+// IsAllFiles reports whether every type is a File or an array of Files,
+// optional types are included.
+func IsAllFiles(tys []CWLType) bool {
 	for _, ty := range tys {
+		if ty.Kind == CWLNullKind {
+			continue
+		}
+		if ty.Kind == CWLArrayKind && ty.Array != nil && IsAllFiles(ty.Array.Items) {
+			continue
+		}
 		if ty.Kind != CWLFileKind {
 			return false
 		}
@@ -28,8 +37,14 @@ func isAllFiles(tys []CWLType) bool {
 	return true
 }
 
-func isAllDirectories(tys []CWLType) bool {
+func IsAllDirectories(tys []CWLType) bool {
 	for _, ty := range tys {
+		if ty.Kind == CWLNullKind {
+			continue
+		}
+		if ty.Kind == CWLArrayKind && ty.Array != nil && IsAllDirectories(ty.Array.Items) {
+			continue
+		}
 		if ty.Kind != CWLDirectoryKind {
 			return false
 		}
@@ -37,12 +52,22 @@ func isAllDirectories(tys []CWLType) bool {
 	return true
 }
 
+// IsOptional reports whether the types accept null.
+func IsOptional(tys []CWLType) bool {
+	for _, ty := range tys {
+		if ty.Kind == CWLNullKind {
+			return true
+		}
+	}
+	return false
+}
+
 // TypeCheckCommandlineInputs checks the validity of command-line inputs.
 func TypeCheckCommandlineInputs(clins []CommandlineInputParameter) error {
 	for _, clin := range clins {
 
-		allFiles := isAllFiles(clin.Type)
-		allDirectories := isAllDirectories(clin.Type)
+		allFiles := IsAllFiles(clin.Type)
+		allDirectories := IsAllDirectories(clin.Type)
 		// type check secondary files
 		if clin.SecondaryFiles != nil {
 			if !allFiles {
@@ -55,7 +80,7 @@ func TypeCheckCommandlineInputs(clins []CommandlineInputParameter) error {
 		if clin.Format != nil && !allFiles {
 			return errors.New("Format only valid when types are of File|[]File")
 		}
-		if clin.LoadContents != nil {
+		if clin.LoadContents != nil && !allFiles {
 			return errors.New("LoadContents only valid when types of File|[]File")
 		}
 		if clin.LoadListing != nil && !allDirectories {
@@ -69,7 +94,7 @@ func TypeCheckCommandlineInputs(clins []CommandlineInputParameter) error {
 func TypeCheckCommandlineOutputs(clouts []CommandlineOutputParameter) error {
 	for _, clout := range clouts {
 
-		allFiles := isAllFiles(clout.Type)
+		allFiles := IsAllFiles(clout.Type)
 		// type check secondary files
 		if clout.SecondaryFiles != nil {
 			if !allFiles {
@@ -115,6 +140,137 @@ func typeCheckDockerRequirement(d *DockerRequirement) error {
 	return nil
 }
 
+func typeCheckToolTimeLimit(t *ToolTimeLimit) error {
+	switch t.TimeLimit.Kind {
+	case IntKind:
+		if t.TimeLimit.Int < 0 {
+			return errors.New("timeLimit must not be negative")
+		}
+	case ExpressionKind:
+	default:
+		return errors.New("timeLimit must be an int or an expression")
+	}
+	return nil
+}
+
+// TypeCheckProteusClasses checks that prefixed proteus classes use a prefix
+// declared in $namespaces for the proteus namespace.
+func TypeCheckProteusClasses(namespaces map[string]string, reqs ...Requirements) error {
+	for _, level := range reqs {
+		for _, req := range level {
+			pod, ok := req.(KubernetesPod)
+			if !ok || pod.Class == ProteusNamespace+KubernetesPodClass {
+				continue
+			}
+			prefix, _, _ := strings.Cut(pod.Class, ":")
+			namespace, ok := namespaces[prefix]
+			if !ok {
+				return fmt.Errorf("namespace %s of %s is not declared in $namespaces", prefix, pod.Class)
+			}
+			if namespace != ProteusNamespace {
+				return fmt.Errorf("namespace %s of %s must be %s", prefix, pod.Class, ProteusNamespace)
+			}
+		}
+	}
+	return nil
+}
+
+// formatValues returns the formats of a CWLFormat with their namespace prefixes
+// expanded. Expressions are resolved at runtime and are not returned.
+func formatValues(format *CWLFormat, namespaces map[string]string) []string {
+	values := make([]string, 0)
+	if format == nil {
+		return values
+	}
+	switch format.Kind {
+	case FormatStringKind:
+		values = append(values, string(format.String))
+	case FormatStringsKind:
+		values = append(values, format.Strings...)
+	}
+
+	expanded := make([]string, 0, len(values))
+	for _, value := range values {
+		if value == "" || strings.Contains(value, "$(") || strings.Contains(value, "${") {
+			continue
+		}
+		expanded = append(expanded, ExpandIRI(value, namespaces))
+	}
+	return expanded
+}
+
+// TypeCheckInputFormat checks that the format of a File provided for an input
+// is the declared format or one of its subclasses in the loaded ontologies.
+// The Files of an array are checked against the format of the input, those of
+// a record against the format of their field.
+func TypeCheckInputFormat(id string, declared *CWLFormat, types CWLTypes, entry CWLInputEntry, namespaces map[string]string, ontology *Ontology) error {
+	switch entry.Kind {
+	case CWLArrayKind:
+		items := make(CWLTypes, 0)
+		for _, ty := range types {
+			if ty.Kind == CWLArrayKind && ty.Array != nil {
+				items = append(items, ty.Array.Items...)
+			}
+		}
+		for idx, item := range entry.Array {
+			if err := TypeCheckInputFormat(fmt.Sprintf("%s[%d]", id, idx), declared, items, item, namespaces, ontology); err != nil {
+				return err
+			}
+		}
+		return nil
+	case CWLRecordKind:
+		for _, ty := range types {
+			if ty.Kind != CWLRecordKind {
+				continue
+			}
+			check := func(name string, format *CWLFormat, fieldTypes CWLTypes) error {
+				value, ok := entry.Record[name]
+				if !ok {
+					return nil
+				}
+				return TypeCheckInputFormat(id+"."+name, format, fieldTypes, value, namespaces, ontology)
+			}
+			switch {
+			case ty.OutputRecord != nil:
+				for _, field := range ty.OutputRecord.Fields {
+					if err := check(field.Name, field.Format, field.Type); err != nil {
+						return err
+					}
+				}
+			case ty.Record != nil && ty.Record.Fields != nil:
+				for _, field := range *ty.Record.Fields {
+					if err := check(field.Name, &field.Format, field.Type); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	if entry.FileData == nil || entry.FileData.Format == nil {
+		return nil
+	}
+	accepted := formatValues(declared, namespaces)
+	if len(accepted) == 0 {
+		return nil
+	}
+
+	for _, format := range formatValues(entry.FileData.Format, namespaces) {
+		compatible := false
+		for _, class := range accepted {
+			if ontology.IsSubClassOf(format, class) {
+				compatible = true
+				break
+			}
+		}
+		if !compatible {
+			return fmt.Errorf("format %s of input %s is not compatible with %s", format, id, strings.Join(accepted, ", "))
+		}
+	}
+	return nil
+}
+
 // TypeCheckCommandlineRequirements checks the requirements for command-line tools.
 func TypeCheckCommandlineRequirements(id *string, clrs []CWLRequirements) error {
 	if clrs == nil {
@@ -165,6 +321,34 @@ func TypeCheckBaseCommand(id *string, baseCommand []string, arguments []string)
 	return errors.New("If len(baseCommand) == 0 then len(arguments) must be > 0")
 }
 
+// TypeCheckExitCodes validates that an exit code is not assigned to more than one outcome.
+func TypeCheckExitCodes(id *string, successCodes []int, temporaryFailCodes []int, permanentFailCodes []int) error {
+	seen := make(map[int]string)
+	outcomes := []struct {
+		name  string
+		codes []int
+	}{
+		{"successCodes", successCodes},
+		{"temporaryFailCodes", temporaryFailCodes},
+		{"permanentFailCodes", permanentFailCodes},
+	}
+	for _, outcome := range outcomes {
+		for _, code := range outcome.codes {
+			if code < 0 || code > 255 {
+				return fmt.Errorf("exit code %d in %s is out of range", code, outcome.name)
+			}
+			if previous, ok := seen[code]; ok && previous != outcome.name {
+				if id != nil {
+					return fmt.Errorf("In %s exit code %d is listed in both %s and %s", *id, code, previous, outcome.name)
+				}
+				return fmt.Errorf("exit code %d is listed in both %s and %s", code, previous, outcome.name)
+			}
+			seen[code] = outcome.name
+		}
+	}
+	return nil
+}
+
 // TypeCheckCommandlineTool checks the overall validity of a command-line tool.
 func TypeCheckCommandlineTool(cl *CommandLineTool, inputs map[string]CWLInputEntry) error {
 	var err error
@@ -188,19 +372,49 @@ func TypeCheckCommandlineTool(cl *CommandLineTool, inputs map[string]CWLInputEnt
 		return err
 	}
 
-	err = TypeCheckCommandlineRequirements(cl.ID, cl.Requirements)
+	err = TypeCheckCommandlineRequirements(cl.ID, ApplyHints(cl.Requirements, cl.Hints))
 	if err != nil {
 		return err
 	}
 
-	// err = TypeCheckCommandlineHints(cl.Id, cl.Hints)
-	// if err != nil {
-	// 	return err
-	// }
+	err = TypeCheckHints(cl.Hints)
+	if err != nil {
+		return err
+	}
+
+	err = TypeCheckProteusClasses(cl.Namespaces, cl.Requirements, Requirements(cl.Hints))
+	if err != nil {
+		return err
+	}
+
+	types := make(map[string]CWLTypes)
+	for _, clin := range cl.Inputs {
+		if clin.ID != nil {
+			types[*clin.ID] = clin.Type
+		}
+	}
+	err = TypeCheckJobOrder(types, inputs)
+	if err != nil {
+		return err
+	}
+
+	for _, clin := range cl.Inputs {
+		if clin.ID == nil {
+			continue
+		}
+		entry, ok := inputs[*clin.ID]
+		if !ok {
+			continue
+		}
+		err = TypeCheckInputFormat(*clin.ID, clin.Format, clin.Type, entry, cl.Namespaces, cl.Ontology)
+		if err != nil {
+			return err
+		}
+	}
 
 	err = TypeCheckCLICWLVersion(cl.ID, cl.CWLVersion)
 	if err != nil {
-		return nil
+		return err
 	}
 
 	err = TypeCheckBaseCommand(cl.ID, cl.BaseCommand, cl.Arguments)
@@ -208,5 +422,10 @@ func TypeCheckCommandlineTool(cl *CommandLineTool, inputs map[string]CWLInputEnt
 		return err
 	}
 
+	err = TypeCheckExitCodes(cl.ID, cl.SuccessCodes, cl.TemporaryFailCodes, cl.PermanentFailCodes)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
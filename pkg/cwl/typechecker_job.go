@@ -0,0 +1,251 @@
+package cwl
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var typeNames = map[Type]string{
+	CWLNullKind:        "null",
+	CWLBoolKind:        "boolean",
+	CWLIntKind:         "int",
+	CWLLongKind:        "long",
+	CWLFloatKind:       "float",
+	CWLDoubleKind:      "double",
+	CWLFileKind:        "File",
+	CWLDirectoryKind:   "Directory",
+	CWLStdinKind:       "stdin",
+	CWLStringKind:      "string",
+	CWLRecordKind:      "record",
+	CWLRecordFieldKind: "record field",
+	CWLEnumKind:        "enum",
+	CWLArrayKind:       "array",
+}
+
+func (t Type) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("Type(%d)", int32(t))
+}
+
+func typesString(tys CWLTypes) string {
+	names := make([]string, 0, len(tys))
+	for _, ty := range tys {
+		names = append(names, ty.Kind.String())
+	}
+	return strings.Join(names, " | ")
+}
+
+// TypeCheckInputValue checks that a job order value matches one of the
+// types of the input it is given for.
+func TypeCheckInputValue(id string, tys CWLTypes, value CWLInputEntry) error {
+	var mismatch error
+	for _, ty := range tys {
+		err := typeCheckValue(id, ty, value)
+		if err == nil {
+			return nil
+		}
+		if mismatch == nil {
+			mismatch = err
+		}
+	}
+	if len(tys) > 1 || mismatch == nil {
+		return fmt.Errorf("%s: %s does not match %s", id, value.Kind, typesString(tys))
+	}
+	return mismatch
+}
+
+func typeCheckValue(id string, ty CWLType, value CWLInputEntry) error {
+	mismatch := fmt.Errorf("%s: %s does not match %s", id, value.Kind, ty.Kind)
+
+	switch ty.Kind {
+	case CWLNullKind, CWLBoolKind, CWLStringKind:
+		if value.Kind != ty.Kind {
+			return mismatch
+		}
+	case CWLIntKind, CWLLongKind:
+		if value.Kind != CWLIntKind {
+			return mismatch
+		}
+	case CWLFloatKind, CWLDoubleKind:
+		if value.Kind != CWLIntKind && value.Kind != CWLFloatKind {
+			return mismatch
+		}
+	case CWLEnumKind:
+		if value.Kind != CWLStringKind {
+			return mismatch
+		}
+		if ty.Enum != nil && len(ty.Enum.Symbols) > 0 && !containsSymbol(ty.Enum.Symbols, *value.StringData) {
+			return fmt.Errorf("%s: %s is not one of %s", id, *value.StringData, strings.Join(ty.Enum.Symbols, ", "))
+		}
+	case CWLFileKind:
+		if value.Kind != CWLFileKind {
+			return mismatch
+		}
+		return typeCheckFileValue(id, value.FileData)
+	case CWLDirectoryKind:
+		if value.Kind != CWLDirectoryKind {
+			return mismatch
+		}
+		return typeCheckDirectoryValue(id, value.DirectoryData)
+	case CWLArrayKind:
+		if value.Kind != CWLArrayKind {
+			return mismatch
+		}
+		if ty.Array == nil || len(ty.Array.Items) == 0 {
+			return nil
+		}
+		for idx, item := range value.Array {
+			if err := TypeCheckInputValue(fmt.Sprintf("%s[%d]", id, idx), ty.Array.Items, item); err != nil {
+				return err
+			}
+		}
+	case CWLRecordKind:
+		if value.Kind != CWLRecordKind {
+			return mismatch
+		}
+		return typeCheckRecordValue(id, ty, value)
+	default:
+		return fmt.Errorf("%s: values of type %s are not supported", id, ty.Kind)
+	}
+	return nil
+}
+
+func containsSymbol(symbols []string, value string) bool {
+	for _, symbol := range symbols {
+		// Symbols may be given as IRIs of the enum
+		if symbol == value || strings.HasSuffix(symbol, "/"+value) || strings.HasSuffix(symbol, "#"+value) {
+			return true
+		}
+	}
+	return false
+}
+
+func typeCheckFileValue(id string, file *CWLFile) error {
+	if file.Location == nil && file.Path == nil && file.Contents == nil {
+		return fmt.Errorf("%s: File requires a location, a path or contents", id)
+	}
+	if file.Checksum != nil {
+		if _, _, err := ParseChecksum(*file.Checksum); err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+	}
+	if file.Size != nil && *file.Size < 0 {
+		return fmt.Errorf("%s: size %d is negative", id, *file.Size)
+	}
+	for idx, secondary := range file.SecondaryFiles {
+		secondaryId := fmt.Sprintf("%s.secondaryFiles[%d]", id, idx)
+		switch secondary.Kind {
+		case CWLFileKind:
+			if err := typeCheckFileValue(secondaryId, secondary.FileData); err != nil {
+				return err
+			}
+		case CWLDirectoryKind:
+			if err := typeCheckDirectoryValue(secondaryId, secondary.DirectoryData); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s: File or Directory expected, got %s", secondaryId, secondary.Kind)
+		}
+	}
+	return nil
+}
+
+func typeCheckDirectoryValue(id string, directory *CWLDirectory) error {
+	if directory.Location == nil && directory.Path == nil && directory.Listing == nil {
+		return fmt.Errorf("%s: Directory requires a location, a path or a listing", id)
+	}
+	for idx, entry := range directory.Listing {
+		entryId := fmt.Sprintf("%s.listing[%d]", id, idx)
+		switch entry.Kind {
+		case CWLFileKind:
+			if err := typeCheckFileValue(entryId, entry.FileData); err != nil {
+				return err
+			}
+		case CWLDirectoryKind:
+			if err := typeCheckDirectoryValue(entryId, entry.DirectoryData); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s: File or Directory expected, got %s", entryId, entry.Kind)
+		}
+	}
+	return nil
+}
+
+func typeCheckRecordValue(id string, ty CWLType, value CWLInputEntry) error {
+	fields := make(map[string]CWLTypes)
+	switch {
+	case ty.OutputRecord != nil:
+		for _, field := range ty.OutputRecord.Fields {
+			fields[field.Name] = field.Type
+		}
+	case ty.Record != nil && ty.Record.Fields != nil:
+		for _, field := range *ty.Record.Fields {
+			fields[field.Name] = field.Type
+		}
+	default:
+		return nil
+	}
+
+	for name, fieldTypes := range fields {
+		fieldValue, ok := value.Record[name]
+		if !ok {
+			fieldValue = CWLInputEntry{Kind: CWLNullKind}
+		}
+		if err := TypeCheckInputValue(id+"."+name, fieldTypes, fieldValue); err != nil {
+			return err
+		}
+	}
+	for name := range value.Record {
+		if _, ok := fields[name]; !ok {
+			return fmt.Errorf("%s: %s is not a field of the record", id, name)
+		}
+	}
+	return nil
+}
+
+// TypeCheckJobOrder checks the values of a job order against the types of the
+// inputs they are given for. Values for unknown inputs are ignored.
+func TypeCheckJobOrder(types map[string]CWLTypes, job map[string]CWLInputEntry) error {
+	for id, value := range job {
+		tys, ok := types[id]
+		if !ok {
+			log.Warnf("%s is not an input, its value is ignored", id)
+			continue
+		}
+		if err := TypeCheckInputValue(id, tys, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checksumLengths holds the hex digest length of the supported checksum
+// algorithms.
+var checksumLengths = map[string]int{
+	"md5":    32,
+	"sha1":   40,
+	"sha256": 64,
+	"sha512": 128,
+}
+
+// ParseChecksum splits a File checksum of the form algorithm$digest.
+func ParseChecksum(checksum string) (string, string, error) {
+	algorithm, digest, found := strings.Cut(checksum, "$")
+	if !found {
+		return "", "", fmt.Errorf("checksum %s must be of the form algorithm$digest", checksum)
+	}
+	length, ok := checksumLengths[algorithm]
+	if !ok {
+		return "", "", fmt.Errorf("checksum algorithm %s is not supported, expected md5, sha1, sha256 or sha512", algorithm)
+	}
+	digest = strings.ToLower(digest)
+	if len(digest) != length || strings.Trim(digest, "0123456789abcdef") != "" {
+		return "", "", fmt.Errorf("checksum %s is not a %s digest", checksum, algorithm)
+	}
+	return algorithm, digest, nil
+}
@@ -3,13 +3,14 @@ package cwl
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 func TypeCheckWorkflowInputParameters(inputs WorkflowInputs) error {
 	for _, wfin := range inputs {
 
-		allFiles := isAllFiles(wfin.Type)
-		allDirectories := isAllDirectories(wfin.Type)
+		allFiles := IsAllFiles(wfin.Type)
+		allDirectories := IsAllDirectories(wfin.Type)
 
 		if wfin.SecondaryFiles != nil {
 			if !allFiles {
@@ -22,7 +23,7 @@ func TypeCheckWorkflowInputParameters(inputs WorkflowInputs) error {
 		if wfin.Format != nil && !allFiles {
 			return errors.New("Format only valid when types are of File|[]File")
 		}
-		if wfin.LoadContents != nil {
+		if wfin.LoadContents != nil && !allFiles {
 			return errors.New("LoadContents only valid when types of File|[]File")
 		}
 		if wfin.LoadListing != nil && !allDirectories {
@@ -35,7 +36,7 @@ func TypeCheckWorkflowInputParameters(inputs WorkflowInputs) error {
 func TypeCheckOutputs(outputs WorkflowOutputs) error {
 	for _, wfout := range outputs {
 
-		allFiles := isAllFiles(wfout.Type)
+		allFiles := IsAllFiles(wfout.Type)
 		// type check secondary files
 		if wfout.SecondaryFiles != nil {
 			if !allFiles {
@@ -52,49 +53,209 @@ func TypeCheckOutputs(outputs WorkflowOutputs) error {
 	return nil
 }
 
-func TypeCheckSteps(steps WorkflowSteps) error {
-	for _, step := range steps {
+// TypeCheckOutputSources checks that every workflow output has a source which
+// refers to a workflow input or to an output declared by a step.
+func TypeCheckOutputSources(wf *Workflow) error {
+	multipleInputs := hasRequirement("MultipleInputFeatureRequirement", wf.Requirements)
+
+	for id, wfout := range wf.Outputs {
+		if len(wfout.OutputSource) == 0 {
+			return fmt.Errorf("outputSource required for %s", id)
+		}
+		if len(wfout.OutputSource) > 1 && !multipleInputs {
+			return fmt.Errorf("MultipleInputFeatureRequirement required for the sources of %s", id)
+		}
 
-		// DockerRequirements must be set for steps
-		var dockerReq bool
-		for _, req := range step.Requirements {
-			if req.getClass() == "DockerRequirement" {
-				dockerReq = true
+		for _, source := range wfout.OutputSource {
+			source = strings.TrimPrefix(source, "#")
+			stepId, outId, found := strings.Cut(source, "/")
+			if !found {
+				if _, ok := wf.Inputs[source]; !ok {
+					return fmt.Errorf("%s is not an input of the workflow", source)
+				}
+				continue
+			}
+			if !stepDeclaresOutput(wf.Steps, stepId, outId) {
+				return fmt.Errorf("%s is not an output of step %s", outId, stepId)
 			}
 		}
-		if !dockerReq {
-			return fmt.Errorf("no DockerRequirement found in step %+v", step.Id)
+	}
+	return nil
+}
+
+// hasRequirement reports whether a requirement of the given class is set on
+// any of the given requirements.
+func hasRequirement(class string, reqs ...Requirements) bool {
+	for _, r := range reqs {
+		for _, req := range r {
+			if req.getClass() == class {
+				return true
+			}
 		}
+	}
+	return false
+}
 
-		// We want to raise an error on scatter arrays greater than 1 where ScatterMethod is not set
-		if (len(step.Scatter.Array) > 1) && (step.ScatterMethod == "") {
-			return errors.New("ScatterMethod must be set when scatter arrays are greater than 1")
+// sourceType returns the type of a step input source, which is either a
+// workflow input or the output of a step.
+func sourceType(wf *Workflow, source string) (CWLTypes, error) {
+	source = strings.TrimPrefix(source, "#")
+	stepId, outId, found := strings.Cut(source, "/")
+	if !found || stepId == "global" {
+		if !found {
+			outId = source
+		}
+		input, ok := wf.Inputs[outId]
+		if !ok {
+			return nil, fmt.Errorf("%s is not an input of the workflow", outId)
+		}
+		return input.Type, nil
+	}
+
+	if !stepDeclaresOutput(wf.Steps, stepId, outId) {
+		return nil, fmt.Errorf("%s is not an output of step %s", outId, stepId)
+	}
+	for _, step := range wf.Steps {
+		if step.Id != stepId {
+			continue
+		}
+		for _, output := range step.Run.Outputs {
+			if output.ID != nil && *output.ID == outId {
+				return output.Type, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s is not an output of the tool run by step %s", outId, stepId)
+}
+
+// TypeCheckStepSources checks that merged step input sources are only used
+// with MultipleInputFeatureRequirement and are bound to array inputs whose
+// items match the sources, and that valueFrom is only used with
+// StepInputExpressionRequirement.
+func TypeCheckStepSources(wf *Workflow) error {
+	for _, step := range wf.Steps {
+		inputs := make(map[string]WorkflowStepInput)
+		for idx, input := range step.In.Array {
+			id := fmt.Sprintf("step-%d", idx)
+			if input.Id != nil {
+				id = *input.Id
+			}
+			inputs[id] = input
+		}
+		for id, input := range step.In.Map {
+			inputs[id] = input
+		}
+
+		for id, input := range inputs {
+			if input.ValueFrom != nil && !hasRequirement("StepInputExpressionRequirement", wf.Requirements, step.Requirements) {
+				return fmt.Errorf("StepInputExpressionRequirement required for the valueFrom of %s in step %s", id, step.Id)
+			}
+			if len(input.Source) > 1 && !hasRequirement("MultipleInputFeatureRequirement", wf.Requirements, step.Requirements) {
+				return fmt.Errorf("MultipleInputFeatureRequirement required for the sources of %s in step %s", id, step.Id)
+			}
+			if len(input.Source) <= 1 && input.LinkMerge == nil {
+				continue
+			}
+
+			var toolType []CWLType
+			for _, toolInput := range step.Run.Inputs {
+				if toolInput.ID != nil && *toolInput.ID == id {
+					toolType = toolInput.Type
+				}
+			}
+			if len(toolType) == 0 {
+				return fmt.Errorf("%s is not an input of the tool run by step %s", id, step.Id)
+			}
+			if toolType[0].Kind != CWLArrayKind || toolType[0].Array == nil || len(toolType[0].Array.Items) == 0 {
+				return fmt.Errorf("input %s of step %s merges several sources and must be an array", id, step.Id)
+			}
+			itemKind := toolType[0].Array.Items[0].Kind
+
+			for _, source := range input.Source {
+				srcType, err := sourceType(wf, source)
+				if err != nil {
+					return err
+				}
+				if len(srcType) == 0 {
+					continue
+				}
+				srcKind := srcType[0].Kind
+
+				// merge_flattened concatenates arrays, so array sources must hold the items
+				if input.LinkMerge != nil && *input.LinkMerge == MergeFlattened && srcKind == CWLArrayKind && srcType[0].Array != nil && len(srcType[0].Array.Items) > 0 {
+					srcKind = srcType[0].Array.Items[0].Kind
+				}
+				if srcKind != itemKind {
+					return fmt.Errorf("source %s of %s in step %s does not match the array items of the input", source, id, step.Id)
+				}
+			}
 		}
 	}
 	return nil
 }
 
-func TypeCheckRequirements(reqs Requirements) error {
-	if len(reqs) != 0 {
-		fmt.Printf("You have tried to set global requirements! These are currently ignored!\n")
+func stepDeclaresOutput(steps WorkflowSteps, stepId string, outId string) bool {
+	for _, step := range steps {
+		if step.Id != stepId {
+			continue
+		}
+		for _, out := range step.Out {
+			if out.Id != nil && *out.Id == outId {
+				return true
+			}
+		}
 	}
-	return nil // errors.New("Requirements currently must be specified per step")
+	return false
 }
 
-func TypeCheckHints(hints Hints) error {
+func TypeCheckSteps(steps WorkflowSteps, inherited Requirements, inheritedHints Hints) error {
+	for _, step := range steps {
+
+		// A DockerRequirement must be in effect for every step, it may be inherited from the workflow or given as a hint
+		effective := ApplyHints(
+			InheritRequirements(inherited, step.Requirements, step.Run.Requirements),
+			InheritHints(inheritedHints, step.Hints, step.Run.Hints),
+		)
+		if err := TypeCheckCommandlineRequirements(&step.Id, effective); err != nil {
+			return err
+		}
 
-	// Need to tidy this up a bit more tomorrow
-	if len(hints.Array) != 0 {
-		fmt.Printf("You have tried to set global hints! These are currently ignored!\n")
+		// We want to raise an error on scatter arrays greater than 1 where ScatterMethod is not set
+		if (len(step.Scatter.Array) > 1) && (step.ScatterMethod == "") {
+			return errors.New("ScatterMethod must be set when scatter arrays are greater than 1")
+		}
 	}
+	return nil
+}
 
-	if len(hints.Map) != 0 {
-		fmt.Printf("You have tried to set global hints! These are currently ignored!\n")
+// typeCheckRequirement checks a single requirement or hint.
+func typeCheckRequirement(requirement CWLRequirements) error {
+	switch req := requirement.(type) {
+	case DockerRequirement:
+		return typeCheckDockerRequirement(&req)
+	case ToolTimeLimit:
+		return typeCheckToolTimeLimit(&req)
 	}
+	return nil
+}
 
+// TypeCheckRequirements checks the requirements of a workflow, which are
+// inherited by its steps.
+func TypeCheckRequirements(reqs Requirements) error {
+	for _, requirement := range reqs {
+		if err := typeCheckRequirement(requirement); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// TypeCheckHints checks the hints which could be parsed, hints of unknown
+// classes have already been dropped with a warning.
+func TypeCheckHints(hints Hints) error {
+	return TypeCheckRequirements(Requirements(hints))
+}
+
 func TypeCheckWorkflow(wf *Workflow, inputs map[string]CWLInputEntry) error {
 
 	err := TypeCheckWorkflowInputParameters(wf.Inputs)
@@ -107,13 +268,41 @@ func TypeCheckWorkflow(wf *Workflow, inputs map[string]CWLInputEntry) error {
 		return err
 	}
 
-	// Currently assumes docker requirements are attached directly to the steps
-	err = TypeCheckSteps(wf.Steps)
+	types := make(map[string]CWLTypes)
+	for id, wfin := range wf.Inputs {
+		types[id] = wfin.Type
+	}
+	err = TypeCheckJobOrder(types, inputs)
+	if err != nil {
+		return err
+	}
+
+	for id, wfin := range wf.Inputs {
+		entry, ok := inputs[id]
+		if !ok {
+			continue
+		}
+		err = TypeCheckInputFormat(id, wfin.Format, wfin.Type, entry, wf.Namespaces, wf.Ontology)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = TypeCheckOutputSources(wf)
+	if err != nil {
+		return err
+	}
+
+	err = TypeCheckStepSources(wf)
+	if err != nil {
+		return err
+	}
+
+	err = TypeCheckSteps(wf.Steps, wf.Requirements, wf.Hints)
 	if err != nil {
 		return err
 	}
 
-	// If there are not requirements on the previous step, but they do exist here, then do not error!
 	err = TypeCheckRequirements(wf.Requirements)
 	if err != nil {
 		return err
@@ -124,5 +313,26 @@ func TypeCheckWorkflow(wf *Workflow, inputs map[string]CWLInputEntry) error {
 		return err
 	}
 
+	err = TypeCheckProteusClasses(wf.Namespaces, wf.Requirements, Requirements(wf.Hints))
+	if err != nil {
+		return err
+	}
+
+	for _, step := range wf.Steps {
+		// Tools loaded from their own document may declare their own namespaces
+		namespaces := make(map[string]string)
+		for prefix, namespace := range wf.Namespaces {
+			namespaces[prefix] = namespace
+		}
+		for prefix, namespace := range step.Run.Namespaces {
+			namespaces[prefix] = namespace
+		}
+
+		err = TypeCheckProteusClasses(namespaces, step.Requirements, Requirements(step.Hints), step.Run.Requirements, Requirements(step.Run.Hints))
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
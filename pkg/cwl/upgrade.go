@@ -0,0 +1,362 @@
+package cwl
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+const cwltoolNamespace = "http://commonwl.org/cwltool#"
+
+// SupportedCWLVersions lists the accepted versions, documents of an earlier
+// version are upgraded to CWLVersion when they are loaded.
+var SupportedCWLVersions = []string{"v1.0", "v1.1", CWLVersion}
+
+// standardisedExtensions maps the cwltool extensions which became part of the
+// standard in v1.1 to their standard class.
+var standardisedExtensions = map[string]string{
+	"LoadListingRequirement":   "LoadListingRequirement",
+	"InplaceUpdateRequirement": "InplaceUpdateRequirement",
+	"NetworkAccess":            "NetworkAccess",
+	"WorkReuse":                "WorkReuse",
+	"TimeLimit":                "ToolTimeLimit",
+}
+
+var processClasses = map[string]bool{
+	"CommandLineTool": true,
+	"ExpressionTool":  true,
+	"Workflow":        true,
+}
+
+// classEntry is a requirement or a hint, given as an object of a list or as
+// the value of a map keyed by class.
+type classEntry struct {
+	class *yaml.Node
+	body  *yaml.Node
+}
+
+func classEntries(node *yaml.Node) []classEntry {
+	entries := make([]classEntry, 0)
+	if node == nil {
+		return entries
+	}
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if class := mappingValue(item, "class"); class != nil {
+				entries = append(entries, classEntry{class: class, body: item})
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			entries = append(entries, classEntry{class: node.Content[i], body: node.Content[i+1]})
+		}
+	}
+	return entries
+}
+
+// upgradeDocument validates the cwlVersion of a document and of the processes
+// it embeds, and rewrites v1.0 and v1.1 processes into their v1.2 form. The
+// root process must declare its cwlVersion.
+func upgradeDocument(root *yaml.Node, path string) error {
+	if root.Kind == yaml.MappingNode && mappingValue(root, "cwlVersion") == nil {
+		return fmt.Errorf("%s: cwlVersion is required on the root process", path)
+	}
+	return upgradeNode(root, "", path)
+}
+
+func upgradeNode(node *yaml.Node, version string, path string) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := upgradeNode(child, version, path); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		if declared := mappingValue(node, "cwlVersion"); declared != nil {
+			if !isSupportedVersion(declared.Value) {
+				return fmt.Errorf("%s: unsupported cwlVersion %s, expected one of %s", path, declared.Value, strings.Join(SupportedCWLVersions, ", "))
+			}
+			version = declared.Value
+		}
+
+		if class := mappingValue(node, "class"); class != nil && processClasses[class.Value] && version != "" && version != CWLVersion {
+			if err := upgradeProcess(node, version, path); err != nil {
+				return err
+			}
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if err := upgradeNode(node.Content[i+1], version, path); err != nil {
+				return err
+			}
+		}
+
+		if declared := mappingValue(node, "cwlVersion"); declared != nil && declared.Value != CWLVersion {
+			log.Infof("%s: upgraded from cwlVersion %s to %s", path, declared.Value, CWLVersion)
+			declared.Value = CWLVersion
+		}
+	}
+	return nil
+}
+
+func isSupportedVersion(version string) bool {
+	for _, supported := range SupportedCWLVersions {
+		if version == supported {
+			return true
+		}
+	}
+	return false
+}
+
+func upgradeProcess(process *yaml.Node, version string, path string) error {
+	name := mappingValue(process, "class").Value
+	if id := mappingValue(process, "id"); id != nil {
+		name = id.Value
+	}
+	rewrite := func(format string, args ...any) {
+		log.Infof("%s: upgrading %s from %s, %s", path, name, version, fmt.Sprintf(format, args...))
+	}
+
+	for _, field := range []string{"requirements", "hints"} {
+		for _, entry := range classEntries(mappingValue(process, field)) {
+			extension, ok := strings.CutPrefix(entry.class.Value, cwltoolNamespace)
+			if !ok {
+				extension, ok = strings.CutPrefix(entry.class.Value, "cwltool:")
+			}
+			standard, standardised := standardisedExtensions[extension]
+			if !ok || !standardised {
+				continue
+			}
+			rewrite("%s replaced by the standard %s", entry.class.Value, standard)
+			entry.class.Value = standard
+
+			if timeLimit := mappingKey(entry.body, "timelimit"); standard == "ToolTimeLimit" && timeLimit != nil {
+				timeLimit.Value = "timeLimit"
+			}
+		}
+	}
+
+	for _, field := range []string{"requirements", "hints"} {
+		for _, entry := range classEntries(mappingValue(process, field)) {
+			if entry.class.Value != "InitialWorkDirRequirement" {
+				continue
+			}
+			err := upgradeListing(mappingValue(entry.body, "listing"), mappingValue(process, "inputs"), rewrite)
+			if err != nil {
+				return fmt.Errorf("%s: %s can not be upgraded from %s, %w", path, name, version, err)
+			}
+		}
+	}
+
+	if version != "v1.0" {
+		return nil
+	}
+
+	upgradeLoadContents(mappingValue(process, "inputs"), rewrite)
+
+	if mappingValue(process, "class").Value != "CommandLineTool" {
+		return nil
+	}
+
+	// v1.0 listed directories deeply and allowed network access by default
+	if !hasClass(process, "LoadListingRequirement") {
+		rewrite("loadListing defaults to deep_listing")
+		addHint(process, "LoadListingRequirement", "loadListing", scalarNode("deep_listing"))
+	}
+	if !hasClass(process, "NetworkAccess") {
+		rewrite("networkAccess defaults to true")
+		addHint(process, "NetworkAccess", "networkAccess", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"})
+	}
+	return nil
+}
+
+// upgradeListing rewrites the listing of an InitialWorkDirRequirement into its
+// v1.2 form. A Dirent without entryname staged the File or Directory its
+// expression returns, which v1.2 does for a plain listing expression, and so
+// is replaced by it. Entries which v1.2 would stage differently are rejected.
+func upgradeListing(listing *yaml.Node, inputs *yaml.Node, rewrite func(string, ...any)) error {
+	if listing == nil || listing.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	for i, item := range listing.Content {
+		if item.Kind == yaml.ScalarNode {
+			if !isExpression(item.Value) {
+				return fmt.Errorf("the listing entry %s is a string, v1.2 only accepts expressions", item.Value)
+			}
+			continue
+		}
+
+		entry := mappingValue(item, "entry")
+		if entry == nil || entry.Kind != yaml.ScalarNode {
+			continue
+		}
+		entryname := mappingValue(item, "entryname")
+
+		if entryname == nil {
+			if !isExpression(entry.Value) {
+				return fmt.Errorf("the Dirent %s has no entryname to write it to", entry.Value)
+			}
+			if writable := mappingValue(item, "writable"); writable == nil || writable.Value != "true" {
+				rewrite("Dirent without entryname replaced by its expression %s", entry.Value)
+				listing.Content[i] = entry
+			}
+			continue
+		}
+
+		// v1.2 writes an array as JSON to the entryname instead of staging it
+		if input, ok := referencedInput(entry.Value); ok && isArrayType(parameterType(inputs, input)) {
+			return fmt.Errorf("the Dirent %s returns the array input %s, remove its entryname to stage the items", entryname.Value, input)
+		}
+	}
+	return nil
+}
+
+func isExpression(value string) bool {
+	return strings.Contains(value, "$(") || strings.Contains(value, "${")
+}
+
+// referencedInput returns the input an expression consisting of a single
+// $(inputs.name) reference refers to.
+func referencedInput(expression string) (string, bool) {
+	inner, ok := strings.CutPrefix(expression, "$(inputs.")
+	if !ok {
+		return "", false
+	}
+	name, ok := strings.CutSuffix(inner, ")")
+	if !ok || strings.ContainsAny(name, ".[( ") {
+		return "", false
+	}
+	return name, true
+}
+
+// parameterType returns the type node of an input, given as a map keyed by id
+// or as a list of parameters with an id.
+func parameterType(inputs *yaml.Node, name string) *yaml.Node {
+	if inputs == nil {
+		return nil
+	}
+	switch inputs.Kind {
+	case yaml.MappingNode:
+		parameter := mappingValue(inputs, name)
+		if parameter != nil && parameter.Kind == yaml.ScalarNode {
+			return parameter
+		}
+		return mappingValue(parameter, "type")
+	case yaml.SequenceNode:
+		for _, parameter := range inputs.Content {
+			if id := mappingValue(parameter, "id"); id != nil && strings.TrimPrefix(id.Value, "#") == name {
+				return mappingValue(parameter, "type")
+			}
+		}
+	}
+	return nil
+}
+
+// isArrayType reports whether a type node is an array or a union with one.
+func isArrayType(ty *yaml.Node) bool {
+	if ty == nil {
+		return false
+	}
+	switch ty.Kind {
+	case yaml.ScalarNode:
+		return strings.HasSuffix(strings.TrimSuffix(ty.Value, "?"), "[]")
+	case yaml.MappingNode:
+		kind := mappingValue(ty, "type")
+		return kind != nil && kind.Value == "array"
+	case yaml.SequenceNode:
+		for _, member := range ty.Content {
+			if isArrayType(member) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeLoadContents moves loadContents from the inputBinding of inputs to
+// the inputs themselves, where it is declared since v1.1.
+func upgradeLoadContents(inputs *yaml.Node, rewrite func(string, ...any)) {
+	if inputs == nil {
+		return
+	}
+
+	parameters := make([]*yaml.Node, 0)
+	switch inputs.Kind {
+	case yaml.SequenceNode:
+		parameters = inputs.Content
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(inputs.Content); i += 2 {
+			parameters = append(parameters, inputs.Content[i+1])
+		}
+	}
+
+	for _, parameter := range parameters {
+		binding := mappingValue(parameter, "inputBinding")
+		loadContents := mappingValue(binding, "loadContents")
+		if loadContents == nil {
+			continue
+		}
+		if mappingValue(parameter, "loadContents") == nil {
+			parameter.Content = append(parameter.Content, scalarNode("loadContents"), loadContents)
+		}
+		removeMappingKey(binding, "loadContents")
+		rewrite("inputBinding.loadContents moved to the input")
+	}
+}
+
+func hasClass(process *yaml.Node, class string) bool {
+	for _, field := range []string{"requirements", "hints"} {
+		for _, entry := range classEntries(mappingValue(process, field)) {
+			if entry.class.Value == class {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addHint adds a hint with a single field to a process, in the form its
+// other hints are given.
+func addHint(process *yaml.Node, class string, field string, value *yaml.Node) {
+	hints := mappingValue(process, "hints")
+	if hints == nil {
+		hints = &yaml.Node{Kind: yaml.SequenceNode}
+		process.Content = append(process.Content, scalarNode("hints"), hints)
+	}
+
+	body := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{scalarNode(field), value}}
+	switch hints.Kind {
+	case yaml.MappingNode:
+		hints.Content = append(hints.Content, scalarNode(class), body)
+	case yaml.SequenceNode:
+		body.Content = append([]*yaml.Node{scalarNode("class"), scalarNode(class)}, body.Content...)
+		hints.Content = append(hints.Content, body)
+	}
+}
+
+// mappingKey returns the key node of key in a mapping node.
+func mappingKey(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i]
+		}
+	}
+	return nil
+}
+
+func removeMappingKey(node *yaml.Node, key string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
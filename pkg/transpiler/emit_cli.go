@@ -4,21 +4,28 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"path"
 	"sort"
+	"strconv"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/intstr"
 
 	"github.com/SerRichard/proteus/pkg/cwl"
 	"github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
 )
 
 const (
-	ArgoType             = "Workflow"
-	ArgoVersion          = "argoproj.io/v1alpha1"
-	volumeClaimName      = "argovolume"
-	volumeClaimMountPath = "/mnt/pvol"
+	ArgoType                = "Workflow"
+	ArgoVersion             = "argoproj.io/v1alpha1"
+	volumeClaimName         = "argovolume"
+	volumeClaimMountPath    = "/mnt/pvol"
+	temporaryFailRetryLimit = 3
+	inputsStagingPath       = "/tmp/proteus/inputs"
+	globalScope             = "global"
 )
 
 // de-sum typed "CommandlineInputParameter
@@ -29,9 +36,11 @@ type flatCommandlineInputParameter struct {
 	StringValue      *string               // string value
 	BoolValue        *bool                 // boolean value
 	IntValue         *int                  // int value
-	ArrayValue       *[]any                // Array value
+	FloatValue       *float64              // float value
+	ArrayValue       []cwl.CWLInputEntry   // Array value
 	Emit             bool                  // boolean value
 	File             *cwl.CWLFile          // file value
+	Directory        *cwl.CWLDirectory     // directory value
 	FileLocationData *cwl.FileLocationData // file location data
 	SecondaryFiles   cwl.SecondaryFiles
 	Streamable       *bool
@@ -41,6 +50,9 @@ type flatCommandlineInputParameter struct {
 	LoadContents     *bool
 	LoadListing      *cwl.LoadListingEnum
 	InputBinding     *cwl.CommandlineBinding
+	Array            *cwl.CommandlineInputArraySchema
+	Paths            []string // staged paths of the items of a File array
+	Items            []string // parameters of the items of a merged array
 }
 
 type flatCommandlineOutputParameter struct {
@@ -54,6 +66,8 @@ type flatCommandlineOutputParameter struct {
 	Id               *string
 	Format           *cwl.CWLFormat
 	OutputBinding    *cwl.CommandlineOutputBinding
+	Record           *cwl.CommandlineOutputRecordSchema
+	Array            *cwl.CommandlineInputArraySchema
 }
 
 func emitDockerRequirement(container *apiv1.Container, d *cwl.DockerRequirement) error {
@@ -130,6 +144,32 @@ func findResourceRequirement(requirements cwl.Requirements) (*cwl.ResourceRequir
 	}
 }
 
+func findToolTimeLimit(requirements cwl.Requirements) *cwl.ToolTimeLimit {
+	for _, req := range requirements {
+		if t, ok := req.(cwl.ToolTimeLimit); ok {
+			return &t
+		}
+	}
+	return nil
+}
+
+// emitToolTimeLimit bounds the run time of the template, a limit of zero
+// means the tool may run indefinitely.
+func emitToolTimeLimit(template *v1alpha1.Template, requirements cwl.Requirements) error {
+	limit := findToolTimeLimit(requirements)
+	if limit == nil {
+		return nil
+	}
+	if limit.TimeLimit.Kind != cwl.IntKind {
+		return fmt.Errorf("timeLimit %s is not supported, an int is expected", limit.TimeLimit.Expression)
+	}
+	if limit.TimeLimit.Int > 0 {
+		deadline := intstr.FromInt(limit.TimeLimit.Int)
+		template.ActiveDeadlineSeconds = &deadline
+	}
+	return nil
+}
+
 func emitInputParams(template *v1alpha1.Template, inputs []flatCommandlineInputParameter) {
 	params := make([]v1alpha1.Parameter, 0)
 	for _, input := range inputs {
@@ -139,15 +179,6 @@ func emitInputParams(template *v1alpha1.Template, inputs []flatCommandlineInputP
 	template.Inputs.Parameters = params
 }
 
-func canFindType(input cwl.CWLInputEntry, tys cwl.CWLTypes) error {
-	for _, currTy := range tys {
-		if currTy.Kind == input.Kind {
-			return nil
-		}
-	}
-	return errors.New("unable to find type")
-}
-
 type CommandlineInputParameter struct {
 	cwl.CommandlineInputParameter
 }
@@ -182,25 +213,32 @@ func (inputParameter CommandlineInputParameter) getInputBindings(inputs map[stri
 		return nil, fmt.Errorf("%s was not present in input", *inputParameter.CommandlineInputParameter.ID)
 	}
 
-	err := canFindType(input, inputParameter.CommandlineInputParameter.Type)
+	err := cwl.TypeCheckInputValue(*inputParameter.CommandlineInputParameter.ID, inputParameter.CommandlineInputParameter.Type, input)
 	if err != nil {
 		return nil, err
 	}
 
 	binding.Type = input.Kind
 	switch input.Kind {
+	case cwl.CWLNullKind:
 	case cwl.CWLStringKind:
 		binding.StringValue = input.StringData
 	case cwl.CWLIntKind:
 		binding.IntValue = input.IntData
+	case cwl.CWLFloatKind:
+		binding.FloatValue = input.FloatData
 	case cwl.CWLFileKind:
 		binding.File = input.FileData
+	case cwl.CWLDirectoryKind:
+		binding.Directory = input.DirectoryData
 	case cwl.CWLBoolKind:
 		binding.BoolValue = input.BoolData
 	case cwl.CWLArrayKind:
 		binding.ArrayValue = input.Array
+	case cwl.CWLRecordKind:
+		return nil, fmt.Errorf("record input %s is not supported, record values can not be passed to a tool", *binding.Id)
 	default:
-		return nil, fmt.Errorf("%T unknown type", input.Kind)
+		return nil, fmt.Errorf("%s values are not supported for input %s", input.Kind, *binding.Id)
 	}
 
 	return &binding, nil
@@ -225,30 +263,37 @@ func (outputParameter CommandlineOutputParameter) getOutputBindings() (*flatComm
 	}
 	ty := outputParameter.CommandlineOutputParameter.Type[0].Kind
 	switch ty {
-	case cwl.CWLStringKind:
+	case cwl.CWLStringKind, cwl.CWLIntKind, cwl.CWLLongKind, cwl.CWLFloatKind, cwl.CWLDoubleKind, cwl.CWLBoolKind:
 		break
-	case cwl.CWLIntKind:
-		break
-	case cwl.CWLFileKind:
+	case cwl.CWLFileKind, cwl.CWLDirectoryKind:
 		break
+	case cwl.CWLRecordKind:
+		binding.Record = outputParameter.CommandlineOutputParameter.Type[0].OutputRecord
+		if binding.Record == nil {
+			return nil, errors.New("record schema expected")
+		}
+	case cwl.CWLArrayKind:
+		binding.Array = outputParameter.CommandlineOutputParameter.Type[0].Array
+		if binding.Array == nil || !cwl.IsAllFiles(binding.Array.Items) {
+			return nil, fmt.Errorf("only File[] array outputs are supported for %s", *binding.Id)
+		}
 	default:
-		return nil, fmt.Errorf("%T unknown type", ty)
+		return nil, fmt.Errorf("%s outputs are not supported for %s", ty, *binding.Id)
 	}
 	binding.Type = ty
 	return &binding, nil
 }
 
+func bindingPosition(binding flatCommandlineInputParameter) int {
+	if binding.InputBinding != nil && binding.InputBinding.Position != nil {
+		return *binding.InputBinding.Position
+	}
+	return 0
+}
+
 func sortBindingsByPosition(bindings []flatCommandlineInputParameter) {
-	sort.Slice(bindings[:], func(i, j int) bool {
-		leftPost := 0
-		rightPost := 0
-		if bindings[i].InputBinding.Position != nil {
-			leftPost = *bindings[i].InputBinding.Position
-		}
-		if bindings[i].InputBinding.Position != nil {
-			rightPost = *bindings[j].InputBinding.Position
-		}
-		return leftPost < rightPost
+	sort.SliceStable(bindings[:], func(i, j int) bool {
+		return bindingPosition(bindings[i]) < bindingPosition(bindings[j])
 	})
 }
 
@@ -265,7 +310,7 @@ func emitArgumentParams(container *apiv1.Container,
 		}
 
 		cmds = append(cmds, arguments[0])
-		skip = false
+		skip = true
 	}
 
 	for _, cmd := range baseCommand {
@@ -277,7 +322,11 @@ func emitArgumentParams(container *apiv1.Container,
 			continue
 		}
 
-		cmds = append(cmds, arg)
+		cleanArg, err := cleanArgs(arg)
+		if err != nil {
+			return err
+		}
+		cmds = append(cmds, cleanArg)
 	}
 
 	sortBindingsByPosition(bindings)
@@ -285,6 +334,11 @@ func emitArgumentParams(container *apiv1.Container,
 	args := make([]string, 0)
 	for _, binding := range bindings {
 
+		// Inputs without an inputBinding or a value are not added to the command line
+		if binding.InputBinding == nil || binding.Type == cwl.CWLNullKind {
+			continue
+		}
+
 		prefix := ""
 		if binding.InputBinding != nil && binding.InputBinding.Prefix != nil {
 			sep := true
@@ -302,15 +356,26 @@ func emitArgumentParams(container *apiv1.Container,
 		var arg string
 		arg = fmt.Sprintf("%s{{inputs.parameters.%s}}", prefix, *binding.Id)
 
-		if binding.Type == cwl.CWLFileKind {
-			if binding.InputBinding == nil {
+		if isArtifactType(binding.Type) {
+			path, err := bindingArtifactPath(binding)
+			if err != nil {
+				return err
+			}
+			arg = prefix + path
+		}
+		items := binding.Paths
+		if binding.Items != nil {
+			items = binding.Items
+		}
+		if items != nil {
+			if binding.InputBinding.ItemSeparator != nil {
+				args = append(args, prefix+strings.Join(items, *binding.InputBinding.ItemSeparator))
 				continue
 			}
-			if binding.File == nil || binding.File.Path == nil {
-				log.Info("binding, ", binding.File)
-				return errors.New("file information was not available")
+			for _, item := range items {
+				args = append(args, prefix+item)
 			}
-			arg = *binding.File.Path
+			continue
 		}
 		args = append(args, arg)
 	}
@@ -333,6 +398,9 @@ func emitArguments(spec *v1alpha1.WorkflowSpec, bindings []flatCommandlineInputP
 		case cwl.CWLIntKind:
 			intString := fmt.Sprintf("%d", *binding.IntValue)
 			params = append(params, v1alpha1.Parameter{Name: *binding.Id, Value: (*v1alpha1.AnyString)(&intString)})
+		case cwl.CWLFloatKind:
+			floatString := strconv.FormatFloat(*binding.FloatValue, 'f', -1, 64)
+			params = append(params, v1alpha1.Parameter{Name: *binding.Id, Value: (*v1alpha1.AnyString)(&floatString)})
 		case cwl.CWLBoolKind:
 			boolString := fmt.Sprintf("%t", *binding.BoolValue)
 			params = append(params, v1alpha1.Parameter{Name: *binding.Id, Value: (*v1alpha1.AnyString)(&boolString)})
@@ -380,7 +448,9 @@ func filterParams(inputs []flatCommandlineInputParameter) []flatCommandlineInput
 	newInputs := make([]flatCommandlineInputParameter, 0)
 	for _, input := range inputs {
 		switch input.Type {
-		case cwl.CWLFileKind:
+		case cwl.CWLNullKind:
+			continue
+		case cwl.CWLFileKind, cwl.CWLDirectoryKind:
 			continue
 		case cwl.CWLRecordFieldKind:
 			continue
@@ -395,9 +465,66 @@ func filterParams(inputs []flatCommandlineInputParameter) []flatCommandlineInput
 	return newInputs
 }
 
+// inputArtifactPath returns where a File or Directory of the job order is
+// staged, its path when given, otherwise its basename in the staging
+// directory of the input.
+func inputArtifactPath(id string, entry cwl.CWLInputEntry) (string, error) {
+	var staged, location, basename *string
+	switch entry.Kind {
+	case cwl.CWLFileKind:
+		staged, location, basename = entry.FileData.Path, entry.FileData.Location, entry.FileData.Basename
+	case cwl.CWLDirectoryKind:
+		staged, location, basename = entry.DirectoryData.Path, entry.DirectoryData.Location, entry.DirectoryData.Basename
+	default:
+		return "", fmt.Errorf("%s is not a File or a Directory", id)
+	}
+
+	if staged != nil {
+		return *staged, nil
+	}
+	name := ""
+	if basename != nil {
+		name = *basename
+	} else if location != nil {
+		name = path.Base(strings.TrimRight(*location, "/"))
+	} else if entry.Kind == cwl.CWLFileKind && cwl.IsFileLiteral(entry.FileData) {
+		// File literals without a basename are named after their input
+		name = id
+	}
+	if name == "" || name == "." || name == "/" {
+		return "", fmt.Errorf("%s requires a path, a location or a basename to be staged", id)
+	}
+	return fmt.Sprintf("%s/%s", stepInputPath(id), name), nil
+}
+
+// bindingArtifactPath returns the path of the File or Directory bound to an input.
+func bindingArtifactPath(binding flatCommandlineInputParameter) (string, error) {
+	switch {
+	case binding.File != nil:
+		return inputArtifactPath(*binding.Id, cwl.CWLInputEntry{Kind: cwl.CWLFileKind, FileData: binding.File})
+	case binding.Directory != nil:
+		return inputArtifactPath(*binding.Id, cwl.CWLInputEntry{Kind: cwl.CWLDirectoryKind, DirectoryData: binding.Directory})
+	}
+	return "", errors.New("file information was not available")
+}
+
+// isArtifactType reports whether values of the type are passed as artifacts.
+func isArtifactType(ty cwl.Type) bool {
+	return ty == cwl.CWLFileKind || ty == cwl.CWLDirectoryKind
+}
+
+// isArtifactArray reports whether an input is an array of Files or Directories.
+func isArtifactArray(input flatCommandlineInputParameter) bool {
+	return input.Type == cwl.CWLArrayKind && input.Array != nil && len(input.Array.Items) > 0 && (cwl.IsAllFiles(input.Array.Items) || cwl.IsAllDirectories(input.Array.Items))
+}
+
+func isArtifactOutput(output flatCommandlineOutputParameter) bool {
+	return isArtifactType(output.Type) || (output.Type == cwl.CWLArrayKind && output.Array != nil)
+}
+
 func needPVC(outputs []flatCommandlineOutputParameter) bool {
 	for _, binding := range outputs {
-		if binding.Type == cwl.CWLFileKind {
+		if isArtifactOutput(binding) {
 			return true
 		}
 	}
@@ -426,26 +553,20 @@ func expressionToQuantity(expr *cwl.CWLExpression) (*resource.Quantity, error) {
 	return &quantity, nil
 }
 
-func emitPVC(spec *v1alpha1.WorkflowSpec, resourceReq *cwl.ResourceRequirement) error {
-	pSpec := apiv1.PersistentVolumeClaimSpec{}
-	resources := apiv1.ResourceRequirements{}
-	resourceMap := make(map[apiv1.ResourceName]resource.Quantity)
+// repositoryOutputKey returns the key an output without a location is stored
+// at in the artifact repository.
+func repositoryOutputKey(id string) string {
+	return fmt.Sprintf("{{workflow.name}}/%s", id)
+}
 
-	quantity, err := expressionToQuantity(resourceReq.OutdirMin)
-	if err != nil {
-		return err
+// emitArtifactRepositoryRef references the artifact repository key-only
+// artifacts are stored in, argo uses its default repository without one.
+func emitArtifactRepositoryRef(spec *v1alpha1.WorkflowSpec, locations cwl.FileLocations) {
+	ref := locations.ArtifactRepository
+	if ref == nil || (ref.ConfigMap == "" && ref.Key == "") {
+		return
 	}
-	resourceMap[apiv1.ResourceStorage] = *quantity
-	resources.Requests = resourceMap
-	pSpec.Resources = resources
-	pSpec.AccessModes = []apiv1.PersistentVolumeAccessMode{apiv1.ReadWriteMany}
-	pVolClaim := apiv1.PersistentVolumeClaim{}
-	pVolClaim.Spec = pSpec
-
-	pVolClaim.Name = volumeClaimName
-
-	spec.VolumeClaimTemplates = []apiv1.PersistentVolumeClaim{pVolClaim}
-	return nil
+	spec.ArtifactRepositoryRef = &v1alpha1.ArtifactRepositoryRef{ConfigMap: ref.ConfigMap, Key: ref.Key}
 }
 
 func emitInputArtifacts(template *v1alpha1.Template, inputs map[string]cwl.CWLInputEntry, locations cwl.FileLocations) error {
@@ -457,7 +578,7 @@ func emitInputArtifacts(template *v1alpha1.Template, inputs map[string]cwl.CWLIn
 	}
 
 	for key, inputEntry := range inputs {
-		if inputEntry.Kind != cwl.CWLFileKind {
+		if !isArtifactType(inputEntry.Kind) {
 			continue
 		}
 		location, ok := locations.Inputs[key]
@@ -465,11 +586,15 @@ func emitInputArtifacts(template *v1alpha1.Template, inputs map[string]cwl.CWLIn
 			return fmt.Errorf("location data not present for %s", key)
 		}
 
+		path, err := inputArtifactPath(key, inputEntry)
+		if err != nil {
+			return err
+		}
+
 		art := v1alpha1.Artifact{}
 		art.Name = location.Name
-		art.Path = *inputEntry.FileData.Path
-		art.HTTP = location.HTTP
-		art.S3 = location.S3
+		art.Path = path
+		art.ArtifactLocation = location.ArtifactLocation()
 		arts = append(arts, art)
 	}
 
@@ -477,51 +602,317 @@ func emitInputArtifacts(template *v1alpha1.Template, inputs map[string]cwl.CWLIn
 	return nil
 }
 
-func evalCommandlineBindingOutputGlob(bglob *cwl.CommandlineOutputBindingGlob) (string, error) {
+// emitInputVerification checks the staged File inputs declaring a checksum
+// or size before the command runs, a mismatch fails the template.
+func emitInputVerification(wrapper *commandWrapper, inputs map[string]cwl.CWLInputEntry) error {
+	keys := make([]string, 0)
+	for key := range inputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		entry := inputs[key]
+		if entry.Kind != cwl.CWLFileKind || (entry.FileData.Checksum == nil && entry.FileData.Size == nil) {
+			continue
+		}
+
+		path, err := inputArtifactPath(key, entry)
+		if err != nil {
+			return err
+		}
+
+		err = emitFileVerification(wrapper, key, path, entry.FileData)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emitFileVerification checks the checksum and size a File declares once it
+// is staged at path.
+func emitFileVerification(wrapper *commandWrapper, id string, path string, file *cwl.CWLFile) error {
+	if file.Checksum == nil && file.Size == nil {
+		return nil
+	}
+
+	var algorithm, digest, size string
+	var err error
+	if file.Checksum != nil {
+		algorithm, digest, err = cwl.ParseChecksum(*file.Checksum)
+		if err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+	}
+	if file.Size != nil {
+		size = strconv.FormatInt(*file.Size, 10)
+	}
+
+	wrapper.addHelper(requireCommandFunc)
+	wrapper.addHelper(verifyFileFunc)
+	wrapper.Pre = append(wrapper.Pre, fmt.Sprintf("proteus_verify_file %s %s %s %s",
+		shellQuote(path), shellQuote(algorithm), shellQuote(digest), shellQuote(size)))
+	return nil
+}
+
+// evalCommandlineBindingOutputGlob returns the glob patterns of an output
+// binding. Parameter references on the inputs are replaced by argo
+// placeholders so they are resolved when the template runs.
+func evalCommandlineBindingOutputGlob(bglob *cwl.CommandlineOutputBindingGlob) ([]string, error) {
 	if bglob == nil {
-		return "", errors.New("output binding invalid")
+		return nil, errors.New("output binding invalid")
 	}
+
+	var patterns []string
 	switch bglob.Kind {
 	case cwl.GlobStringKind:
-		return *bglob.String, nil
+		if bglob.String == nil {
+			return nil, nil
+		}
+		patterns = []string{*bglob.String}
+	case cwl.GlobStringsKind:
+		patterns = bglob.Strings
+	case cwl.GlobExpressionKind:
+		if _, err := parameterReference(bglob.Expression); err != nil {
+			return nil, err
+		}
+		patterns = []string{bglob.Expression.Expression}
 	default:
-		return "", errors.New("only string is supported at the moment")
+		return nil, fmt.Errorf("%v is not a supported glob kind", bglob.Kind)
+	}
+
+	globs := make([]string, 0)
+	for _, pattern := range patterns {
+		glob, err := cleanArgs(pattern)
+		if err != nil {
+			return nil, err
+		}
+		globs = append(globs, glob)
+	}
+	return globs, nil
+}
+
+func hasGlobWildcard(glob string) bool {
+	return strings.ContainsAny(glob, "*?[")
+}
+
+// isLiteralGlob reports whether the globs name exactly one file without wildcards.
+func isLiteralGlob(globs []string) bool {
+	return len(globs) == 1 && !hasGlobWildcard(globs[0])
+}
+
+// resolveOutputPath makes relative globs absolute against the container working directory.
+func resolveOutputPath(container *apiv1.Container, glob string) string {
+	if container != nil && container.WorkingDir != "" && !path.IsAbs(glob) {
+		return path.Join(container.WorkingDir, glob)
 	}
+	return glob
 }
 
-func emitOutputArtifact(tmpl *v1alpha1.Template, output flatCommandlineOutputParameter, locations cwl.FileLocations) error {
+// collectOutputFiles adds the post-processing needed to gather globbed files
+// into a single path argo can upload, and returns that path. A File is copied
+// from its first match, a File[] is gathered into a directory alongside a
+// manifest of the collected basenames.
+func collectOutputFiles(output flatCommandlineOutputParameter, globs []string, wrapper *commandWrapper) string {
+	scratch := outputScratchFile(*output.Id)
+	wrapper.addPostOnce(fmt.Sprintf("mkdir -p %s", outputsScratchPath))
+
+	if isArtifactType(output.Type) {
+		wrapper.Post = append(wrapper.Post, fmt.Sprintf("cp -r %s %s", globFirstMatch(globPattern(globs)), scratch))
+		return scratch
+	}
+
+	wrapper.Post = append(wrapper.Post,
+		fmt.Sprintf("mkdir -p %s", scratch),
+		fmt.Sprintf(`for f in %s; do [ -e "$f" ] && cp -r "$f" %s/; done`, globPattern(globs), scratch),
+		fmt.Sprintf(`ls -1 %s | awk 'BEGIN { printf "[" } NR>1 { printf "," } { printf "\"%%s\"", $0 } END { printf "]" }' > %s`, scratch, manifestScratchFile(*output.Id)))
+	return scratch
+}
+
+// emitOutputArtifact exposes a File, Directory or File[] output as an output
+// artifact. Artifacts passed between workflow steps need no location, the
+// artifact repository of the cluster is used to hand them over.
+func emitOutputArtifact(tmpl *v1alpha1.Template, output flatCommandlineOutputParameter, locations cwl.FileLocations, passArtifacts bool, wrapper *commandWrapper) error {
 
 	// If there are no locations, do not try to infer an artifact should exist.
-	if len(locations.Outputs) == 0 {
+	if len(locations.Outputs) == 0 && !passArtifacts && locations.ArtifactRepository == nil {
 		return nil
 	}
 
-	if output.Type != cwl.CWLFileKind {
+	if !isArtifactOutput(output) {
 		return errors.New("emitOutputArtifact only accepts CWLFileKind")
 	}
 
-	path, err := evalCommandlineBindingOutputGlob(&output.OutputBinding.Glob)
+	if output.OutputBinding == nil {
+		return fmt.Errorf("outputBinding required for %s", *output.Id)
+	}
+
+	globs, err := evalCommandlineBindingOutputGlob(&output.OutputBinding.Glob)
 	if err != nil {
 		return err
 	}
+	if len(globs) == 0 {
+		return fmt.Errorf("glob required for %s", *output.Id)
+	}
 
 	location, ok := locations.Outputs[*output.Id]
-	if !ok {
-		return fmt.Errorf("unable to find output for %s", *output.Id)
+	if !ok && !passArtifacts {
+		if locations.ArtifactRepository == nil {
+			return fmt.Errorf("unable to find output for %s", *output.Id)
+		}
+		location = cwl.KeyOnlyLocation(*output.Id, repositoryOutputKey(*output.Id))
 	}
-	art := v1alpha1.Artifact{Name: *output.Id, Path: path}
-	art.HTTP = location.HTTP
-	art.S3 = location.S3
 
+	art := v1alpha1.Artifact{Name: *output.Id}
+	if isArtifactType(output.Type) && isLiteralGlob(globs) {
+		art.Path = resolveOutputPath(tmpl.Container, globs[0])
+	} else {
+		art.Path = collectOutputFiles(output, globs, wrapper)
+	}
+	art.ArtifactLocation = location.ArtifactLocation()
+	options, err := outputArtifactOptions(output, locations.Artifacts, location.ArtifactOptions)
+	if err != nil {
+		return err
+	}
+	emitArtifactOptions(&art, options)
+	if location.ArtifactGC != "" {
+		art.ArtifactGC = &v1alpha1.ArtifactGC{Strategy: location.ArtifactGC}
+	}
 	tmpl.Outputs.Artifacts = append(tmpl.Outputs.Artifacts, art)
+
+	if output.Type == cwl.CWLFileKind {
+		emitOutputFileDigest(tmpl, *output.Id, art.Path, wrapper)
+	}
+
+	if output.Type == cwl.CWLArrayKind {
+		manifest := v1alpha1.Parameter{
+			Name:      manifestParameterName(*output.Id),
+			ValueFrom: &v1alpha1.ValueFrom{Path: manifestScratchFile(*output.Id)},
+		}
+		tmpl.Outputs.Parameters = append(tmpl.Outputs.Parameters, manifest)
+	}
 	return nil
 }
 
-func emitOutputs(tmpl *v1alpha1.Template, outputs []flatCommandlineOutputParameter, locations cwl.FileLocations) error {
+// emitOutputFileDigest records the checksum and size of an output File as
+// output parameters, once the command has written it. Optional Files which
+// were not produced record null for both.
+func emitOutputFileDigest(tmpl *v1alpha1.Template, id string, path string, wrapper *commandWrapper) {
+	quoted := shellQuote(path)
+	wrapper.addHelper(requireCommandFunc)
+	wrapper.addPostOnce(fmt.Sprintf("proteus_require_command %ssum 'record the checksum of output Files'", outputChecksumAlgorithm))
+	wrapper.addPostOnce(fmt.Sprintf("mkdir -p %s", outputsScratchPath))
+	wrapper.Post = append(wrapper.Post,
+		fmt.Sprintf("if [ -f %s ]; then", quoted),
+		fmt.Sprintf(`printf '%s$%%s' "$(%ssum %s | cut -d ' ' -f 1)" > %s`, outputChecksumAlgorithm, outputChecksumAlgorithm, quoted, checksumScratchFile(id)),
+		fmt.Sprintf("wc -c < %s | tr -d '[:space:]' > %s", quoted, sizeScratchFile(id)),
+		"else",
+		fmt.Sprintf("printf null > %s", checksumScratchFile(id)),
+		fmt.Sprintf("printf null > %s", sizeScratchFile(id)),
+		"fi")
+
+	tmpl.Outputs.Parameters = append(tmpl.Outputs.Parameters,
+		v1alpha1.Parameter{Name: checksumParameterName(id), ValueFrom: &v1alpha1.ValueFrom{Path: checksumScratchFile(id)}},
+		v1alpha1.Parameter{Name: sizeParameterName(id), ValueFrom: &v1alpha1.ValueFrom{Path: sizeScratchFile(id)}})
+}
+
+// outputArtifactOptions merges the artifact options of an output over those of
+// every artifact and checks the result. Files are stored as they are and
+// Directories, as well as the directory File[] are collected into, as tar
+// archives unless configured otherwise. A compression level set for every
+// artifact only applies to the outputs archived as tar.
+func outputArtifactOptions(output flatCommandlineOutputParameter, artifacts cwl.ArtifactOptions, own cwl.ArtifactOptions) (cwl.ArtifactOptions, error) {
+	options := artifacts.Override(own)
+	if options.Archive == "" {
+		options.Archive = cwl.ArchiveTar
+		if output.Type == cwl.CWLFileKind {
+			options.Archive = cwl.ArchiveNone
+		}
+	}
+	if own.CompressionLevel == nil && options.Archive != cwl.ArchiveTar {
+		options.CompressionLevel = nil
+	}
+
+	err := options.Validate()
+	if err != nil {
+		return options, fmt.Errorf("output %s: %w", *output.Id, err)
+	}
+	return options, nil
+}
+
+// emitArtifactOptions sets the archive of an output artifact.
+func emitArtifactOptions(art *v1alpha1.Artifact, options cwl.ArtifactOptions) {
+	switch options.Archive {
+	case cwl.ArchiveNone:
+		art.Archive = &v1alpha1.ArchiveStrategy{None: &v1alpha1.NoneStrategy{}}
+	case cwl.ArchiveTar:
+		art.Archive = &v1alpha1.ArchiveStrategy{Tar: &v1alpha1.TarStrategy{CompressionLevel: options.CompressionLevel}}
+	case cwl.ArchiveZip:
+		art.Archive = &v1alpha1.ArchiveStrategy{Zip: &v1alpha1.ZipStrategy{}}
+	}
+}
+
+// emitArtifactGC sets the garbage collection of every artifact of the workflow.
+func emitArtifactGC(spec *v1alpha1.WorkflowSpec, locations cwl.FileLocations) {
+	if locations.Artifacts.ArtifactGC == "" {
+		return
+	}
+	spec.ArtifactGC = &v1alpha1.WorkflowLevelArtifactGC{ArtifactGC: v1alpha1.ArtifactGC{Strategy: locations.Artifacts.ArtifactGC}}
+}
+
+// emitOutputParameter exposes a non File output as an output parameter. Plain
+// globs are read by argo directly, anything requiring loadContents, outputEval
+// or record assembly is computed by a post-processing script.
+func emitOutputParameter(tmpl *v1alpha1.Template, output flatCommandlineOutputParameter, wrapper *commandWrapper) error {
+	param := v1alpha1.Parameter{Name: *output.Id, ValueFrom: &v1alpha1.ValueFrom{}}
+
+	var value string
+	var err error
+	if output.Record != nil {
+		value, err = recordValueScript(output.Record)
+		wrapper.addHelper(jsonStringFunc)
+	} else {
+		if output.OutputBinding == nil {
+			return fmt.Errorf("outputBinding required for %s", *output.Id)
+		}
+
+		var globs []string
+		globs, err = evalCommandlineBindingOutputGlob(&output.OutputBinding.Glob)
+		if err != nil {
+			return err
+		}
+
+		if output.OutputBinding.OutputEval.IsEmpty() && isLiteralGlob(globs) {
+			param.ValueFrom.Path = resolveOutputPath(tmpl.Container, globs[0])
+			tmpl.Outputs.Parameters = append(tmpl.Outputs.Parameters, param)
+			return nil
+		}
+		value, err = outputValueScript(output.OutputBinding, globPattern(globs))
+	}
+	if err != nil {
+		return fmt.Errorf("unable to evaluate output %s: %w", *output.Id, err)
+	}
+
+	scratch := outputScratchFile(*output.Id)
+	wrapper.addPostOnce(fmt.Sprintf("mkdir -p %s", outputsScratchPath))
+	wrapper.Post = append(wrapper.Post, fmt.Sprintf("%s > %s", value, scratch))
+
+	param.ValueFrom.Path = scratch
+	tmpl.Outputs.Parameters = append(tmpl.Outputs.Parameters, param)
+	return nil
+}
+
+func emitOutputs(tmpl *v1alpha1.Template, outputs []flatCommandlineOutputParameter, locations cwl.FileLocations, passArtifacts bool, wrapper *commandWrapper) error {
 	for _, output := range outputs {
 		switch output.Type {
-		case cwl.CWLFileKind:
-			err := emitOutputArtifact(tmpl, output, locations)
+		case cwl.CWLFileKind, cwl.CWLDirectoryKind, cwl.CWLArrayKind:
+			err := emitOutputArtifact(tmpl, output, locations, passArtifacts, wrapper)
+			if err != nil {
+				return err
+			}
+		case cwl.CWLStringKind, cwl.CWLIntKind, cwl.CWLLongKind, cwl.CWLFloatKind, cwl.CWLDoubleKind, cwl.CWLBoolKind, cwl.CWLRecordKind:
+			err := emitOutputParameter(tmpl, output, wrapper)
 			if err != nil {
 				return err
 			}
@@ -532,6 +923,37 @@ func emitOutputs(tmpl *v1alpha1.Template, outputs []flatCommandlineOutputParamet
 	return nil
 }
 
+func exitCodeList(codes []int) string {
+	strs := make([]string, 0)
+	for _, code := range codes {
+		strs = append(strs, strconv.Itoa(code))
+	}
+	return "[" + strings.Join(strs, ", ") + "]"
+}
+
+// emitExitCodes maps the CWL exit code outcomes onto the template. Extra
+// success codes are rewritten to 0 by the command wrapper, temporary failures
+// are retried through the retry strategy and any other non zero exit code,
+// including the permanent failure codes, fails the node without a retry.
+func emitExitCodes(tmpl *v1alpha1.Template, clTool *cwl.CommandLineTool, wrapper *commandWrapper) {
+	for _, code := range clTool.SuccessCodes {
+		if code != 0 {
+			wrapper.SuccessCodes = append(wrapper.SuccessCodes, code)
+		}
+	}
+
+	if len(clTool.TemporaryFailCodes) == 0 {
+		return
+	}
+
+	limit := intstr.FromInt(temporaryFailRetryLimit)
+	tmpl.RetryStrategy = &v1alpha1.RetryStrategy{
+		Limit:       &limit,
+		RetryPolicy: v1alpha1.RetryPolicyOnFailure,
+		Expression:  fmt.Sprintf("asInt(lastRetry.exitCode) in %s", exitCodeList(clTool.TemporaryFailCodes)),
+	}
+}
+
 func attachVolume(container *apiv1.Container, volumeName string, mountpath string) {
 	if container.WorkingDir != "" {
 		mountpath = container.WorkingDir
@@ -541,85 +963,125 @@ func attachVolume(container *apiv1.Container, volumeName string, mountpath strin
 	mnt.Name = volumeName
 
 	mnt.MountPath = mountpath
-	container.VolumeMounts = []apiv1.VolumeMount{mnt}
+	container.VolumeMounts = append(container.VolumeMounts, mnt)
 }
 
-func EmitCommandlineTool(clTool *cwl.CommandLineTool, inputs map[string]cwl.CWLInputEntry, locations cwl.FileLocations) (*v1alpha1.Workflow, error) {
-	var wf v1alpha1.Workflow
-	var err error
-
-	wf.Name = *clTool.ID
-	spec := v1alpha1.WorkflowSpec{}
-	wf.APIVersion = ArgoVersion
-	wf.Kind = ArgoType
-
+// emitCommandlineTemplate emits the template running a CommandLineTool. The
+// standalone and the workflow step emission share it, so a tool produces the
+// same command line in both. The returned wrapper still has to be applied to
+// the container once the caller has added its own snippets.
+func emitCommandlineTemplate(clTool *cwl.CommandLineTool, requirements cwl.Requirements, bindings []flatCommandlineInputParameter, locations cwl.FileLocations, passArtifacts bool) (*v1alpha1.Template, []flatCommandlineOutputParameter, *commandWrapper, error) {
 	container := apiv1.Container{}
 
-	dockerRequirement, err := findDockerRequirement(clTool.Requirements)
+	dockerRequirement, err := findDockerRequirement(requirements)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 
 	err = emitDockerRequirement(&container, dockerRequirement)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 
 	template := v1alpha1.Template{}
 	template.Container = &container
-	template.Name = *clTool.ID
+	if clTool.ID != nil {
+		template.Name = *clTool.ID
+	}
 
-	bindings, err := flattenInput(&clTool.Inputs, inputs)
+	emitInputParams(&template, filterParams(bindings))
+
+	outputBindings, err := flattenOutput(&clTool.Outputs)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
+	}
+
+	err = emitArgumentParams(&container, clTool.BaseCommand, clTool.Arguments, bindings)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	wrapper := commandWrapper{}
+	err = emitOutputs(&template, outputBindings, locations, passArtifacts, &wrapper)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
-	paramBindings := filterParams(bindings)
+	emitExitCodes(&template, clTool, &wrapper)
 
-	emitInputParams(&template, paramBindings)
+	err = emitToolTimeLimit(&template, requirements)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
-	outputBindings, err := flattenOutput(&clTool.Outputs)
+	err = emitTmpdir(&template, requirements)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return &template, outputBindings, &wrapper, nil
+}
+
+func EmitCommandlineTool(clTool *cwl.CommandLineTool, inputs map[string]cwl.CWLInputEntry, locations cwl.FileLocations, volume VolumeOptions) (*v1alpha1.Workflow, error) {
+	var wf v1alpha1.Workflow
+	var err error
+
+	wf.Name = *clTool.ID
+	spec := v1alpha1.WorkflowSpec{}
+	wf.APIVersion = ArgoVersion
+	wf.Kind = ArgoType
+
+	bindings, err := flattenInput(&clTool.Inputs, inputs)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Info("Need pvc? ", outputBindings)
+	// Hints apply unless a requirement of the same class overrides them
+	requirements := cwl.ApplyHints(clTool.Requirements, clTool.Hints)
+
+	template, outputBindings, wrapper, err := emitCommandlineTemplate(clTool, requirements, bindings, locations, false)
+	if err != nil {
+		return nil, err
+	}
 
-	if needPVC(outputBindings) {
+	// Outputs stored in the artifact repository are uploaded from the container
+	// itself and need no volume
+	if needsVolume(volume, needPVC(outputBindings) && locations.ArtifactRepository == nil) {
 
-		resourceRequirement, err := findResourceRequirement(clTool.Requirements)
+		outdirMin, err := outdirQuantity(requirements)
 		if err != nil {
 			return nil, err
 		}
 
-		err = emitPVC(&spec, resourceRequirement)
+		err = emitVolume(&spec, volume, outdirMin)
 		if err != nil {
 			return nil, err
 		}
-		attachVolume(&container, volumeClaimName, volumeClaimMountPath)
+		attachVolume(template.Container, volumeClaimName, volumeClaimMountPath)
 	}
 
-	err = emitArgumentParams(&container, clTool.BaseCommand, clTool.Arguments, bindings)
+	err = emitArguments(&spec, filterParams(bindings))
 	if err != nil {
 		return nil, err
 	}
 
-	err = emitArguments(&spec, paramBindings)
+	err = emitInputArtifacts(template, inputs, locations)
 	if err != nil {
 		return nil, err
 	}
 
-	err = emitInputArtifacts(&template, inputs, locations)
+	err = emitInputVerification(wrapper, inputs)
 	if err != nil {
 		return nil, err
 	}
 
-	err = emitOutputs(&template, outputBindings, locations)
-	if err != nil {
-		return nil, err
-	}
+	applyCommandWrapper(template.Container, wrapper)
+
+	emitPodSettings(template, &spec, findKubernetesPod(requirements))
+	emitArtifactRepositoryRef(&spec, locations)
+	emitArtifactGC(&spec, locations)
 
-	spec.Templates = []v1alpha1.Template{template}
+	spec.Templates = []v1alpha1.Template{*template}
 	spec.Entrypoint = template.Name
 
 	wf.Spec = spec
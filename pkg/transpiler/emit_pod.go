@@ -0,0 +1,75 @@
+package transpiler
+
+import (
+	"github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/SerRichard/proteus/pkg/cwl"
+)
+
+// findKubernetesPod returns the proteus pod settings in effect, if any.
+func findKubernetesPod(requirements cwl.Requirements) *cwl.KubernetesPod {
+	for _, req := range requirements {
+		if pod, ok := req.(cwl.KubernetesPod); ok {
+			return &pod
+		}
+	}
+	return nil
+}
+
+// emitPodSettings sets the pod settings on a template. Image pull secrets
+// can only be set for the whole workflow and are added to its spec.
+func emitPodSettings(template *v1alpha1.Template, spec *v1alpha1.WorkflowSpec, pod *cwl.KubernetesPod) {
+	if pod == nil {
+		return
+	}
+
+	if pod.NodeSelector != nil {
+		template.NodeSelector = pod.NodeSelector
+	}
+	if pod.Tolerations != nil {
+		template.Tolerations = pod.Tolerations
+	}
+	if pod.Affinity != nil {
+		template.Affinity = pod.Affinity
+	}
+	if pod.ServiceAccountName != "" {
+		template.ServiceAccountName = pod.ServiceAccountName
+	}
+	if pod.PriorityClassName != "" {
+		template.PriorityClassName = pod.PriorityClassName
+	}
+	if pod.SecurityContext != nil {
+		template.SecurityContext = pod.SecurityContext
+	}
+	addImagePullSecrets(spec, pod.ImagePullSecrets)
+}
+
+// emitWorkflowPodDefaults sets the pod settings as defaults of the workflow.
+func emitWorkflowPodDefaults(spec *v1alpha1.WorkflowSpec, pod *cwl.KubernetesPod) {
+	if pod == nil {
+		return
+	}
+
+	spec.NodeSelector = pod.NodeSelector
+	spec.Tolerations = pod.Tolerations
+	spec.Affinity = pod.Affinity
+	spec.ServiceAccountName = pod.ServiceAccountName
+	spec.PodPriorityClassName = pod.PriorityClassName
+	spec.SecurityContext = pod.SecurityContext
+	addImagePullSecrets(spec, pod.ImagePullSecrets)
+}
+
+func addImagePullSecrets(spec *v1alpha1.WorkflowSpec, secrets []apiv1.LocalObjectReference) {
+	for _, secret := range secrets {
+		found := false
+		for _, existing := range spec.ImagePullSecrets {
+			if existing.Name == secret.Name {
+				found = true
+			}
+		}
+		if !found {
+			spec.ImagePullSecrets = append(spec.ImagePullSecrets, secret)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package transpiler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/SerRichard/proteus/pkg/cwl"
+)
+
+const (
+	wrapperShell       = "/bin/sh"
+	outputsScratchPath = "/tmp/proteus/outputs"
+	loadContentsLimit  = 64 * 1024
+)
+
+// commandWrapper collects shell snippets which have to run inside the
+// tool container around the command described by the CWL document.
+type commandWrapper struct {
+	Helpers []string
+	Pre     []string
+	Post    []string
+	// non zero exit codes which are reported to argo as success
+	SuccessCodes []int
+}
+
+// addHelper registers a shell function definition once.
+func (w *commandWrapper) addHelper(helper string) {
+	for _, h := range w.Helpers {
+		if h == helper {
+			return
+		}
+	}
+	w.Helpers = append(w.Helpers, helper)
+}
+
+func (w *commandWrapper) isEmpty() bool {
+	return len(w.Pre) == 0 && len(w.Post) == 0 && len(w.SuccessCodes) == 0
+}
+
+// addPostOnce appends a post-processing line unless it is already present.
+func (w *commandWrapper) addPostOnce(line string) {
+	for _, l := range w.Post {
+		if l == line {
+			return
+		}
+	}
+	w.Post = append(w.Post, line)
+}
+
+// shellQuote quotes s so that it is passed verbatim as a single shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// applyCommandWrapper rewrites the container so that the original command is
+// executed through a shell script running the wrapper snippets. The original
+// command and arguments are passed as positional parameters, so argo
+// placeholders in them keep working.
+func applyCommandWrapper(container *apiv1.Container, w *commandWrapper) {
+	if w.isEmpty() {
+		return
+	}
+
+	lines := make([]string, 0)
+	lines = append(lines, w.Helpers...)
+	lines = append(lines, w.Pre...)
+	lines = append(lines, `"$0" "$@"`, "rc=$?")
+	if len(w.SuccessCodes) > 0 {
+		codes := make([]string, 0)
+		for _, code := range w.SuccessCodes {
+			codes = append(codes, strconv.Itoa(code))
+		}
+		lines = append(lines, fmt.Sprintf(`case "$rc" in %s) rc=0 ;; esac`, strings.Join(codes, "|")))
+	}
+	if len(w.Post) > 0 {
+		lines = append(lines, `if [ "$rc" -eq 0 ]; then`)
+		lines = append(lines, w.Post...)
+		lines = append(lines, "fi")
+	}
+	lines = append(lines, `exit "$rc"`)
+
+	args := make([]string, 0)
+	args = append(args, strings.Join(lines, "\n"))
+	args = append(args, container.Command...)
+	args = append(args, container.Args...)
+
+	container.Command = []string{wrapperShell, "-c"}
+	container.Args = args
+}
+
+// jsonStringFunc is a shell helper which prints its argument as a JSON string.
+const jsonStringFunc = `proteus_json_string() { printf '"'; printf '%s' "$1" | sed -e 's/\\/\\\\/g' -e 's/"/\\"/g' -e 's/\t/\\t/g' | awk 'NR>1{printf "\\n"} {printf "%s", $0}'; printf '"'; }`
+
+// requireCommandFunc is a shell helper failing the command when a tool the
+// wrapper relies on is missing from the image, the second argument tells what
+// it is needed for.
+const requireCommandFunc = `proteus_require_command() { if ! command -v "$1" > /dev/null 2>&1; then echo "proteus: $1 is required to $2 but is not available in the image" >&2; exit 1; fi; }`
+
+// verifyFileFunc is a shell helper failing the command when a staged file
+// does not have the expected size or checksum. Empty expectations are skipped.
+// It relies on requireCommandFunc.
+const verifyFileFunc = `proteus_verify_file() { if [ ! -f "$1" ]; then echo "proteus: $1 was not staged" >&2; exit 1; fi; if [ -n "$4" ] && [ "$(wc -c < "$1" | tr -d ' ')" != "$4" ]; then echo "proteus: size of $1 does not match $4 bytes" >&2; exit 1; fi; if [ -n "$2" ]; then proteus_require_command "$2sum" "verify the checksum of $1"; if [ "$("$2sum" "$1" | cut -d ' ' -f 1)" != "$3" ]; then echo "proteus: $2 checksum of $1 does not match $3" >&2; exit 1; fi; fi; }`
+
+// outputChecksumAlgorithm is the algorithm of the checksum recorded for
+// output Files, the one CWL runners report.
+const outputChecksumAlgorithm = "sha1"
+
+func outputScratchFile(id string) string {
+	return fmt.Sprintf("%s/%s", outputsScratchPath, id)
+}
+
+func manifestScratchFile(id string) string {
+	return fmt.Sprintf("%s/%s.manifest", outputsScratchPath, id)
+}
+
+func manifestParameterName(id string) string {
+	return id + "-manifest"
+}
+
+func checksumScratchFile(id string) string {
+	return fmt.Sprintf("%s/%s.checksum", outputsScratchPath, id)
+}
+
+func checksumParameterName(id string) string {
+	return id + "-checksum"
+}
+
+func sizeScratchFile(id string) string {
+	return fmt.Sprintf("%s/%s.size", outputsScratchPath, id)
+}
+
+func sizeParameterName(id string) string {
+	return id + "-size"
+}
+
+// globPattern joins several globs into a single shell word list.
+func globPattern(globs []string) string {
+	return strings.Join(globs, " ")
+}
+
+// parameterReference returns the body of a CWL parameter reference $(...),
+// javascript expressions are rejected as there is no engine to run them.
+func parameterReference(expr cwl.CWLExpression) (string, error) {
+	if expr.Kind != cwl.ExpressionKind {
+		return "", fmt.Errorf("%v is not an expression", expr)
+	}
+	if strings.HasPrefix(expr.Expression, "${") {
+		return "", fmt.Errorf("javascript expression %s is not supported", expr.Expression)
+	}
+	return strings.TrimSpace(expr.Expression[2 : len(expr.Expression)-1]), nil
+}
+
+func globMatches(glob string) string {
+	return fmt.Sprintf("ls -1d %s 2>/dev/null", glob)
+}
+
+func globFirstMatch(glob string) string {
+	return fmt.Sprintf(`"$(%s | head -n 1)"`, globMatches(glob))
+}
+
+// selfReferenceScript translates a reference on the globbed files (self) into
+// a shell pipeline printing the referenced value.
+func selfReferenceScript(ref string, glob string, loadContents bool) (string, error) {
+	for _, fn := range []string{"parseInt", "parseFloat", "Number"} {
+		if strings.HasPrefix(ref, fn+"(") && strings.HasSuffix(ref, ")") {
+			inner, err := selfReferenceScript(ref[len(fn)+1:len(ref)-1], glob, loadContents)
+			if err != nil {
+				return "", err
+			}
+			if fn == "parseInt" {
+				return fmt.Sprintf(`%s | awk '{ printf "%%d", $0; exit }'`, inner), nil
+			}
+			return fmt.Sprintf(`%s | tr -d '[:space:]'`, inner), nil
+		}
+	}
+
+	switch ref {
+	case "self[0].contents", "self[0].contents.trim()":
+		if !loadContents {
+			return "", fmt.Errorf("%s requires loadContents to be set", ref)
+		}
+		script := fmt.Sprintf("head -c %d %s", loadContentsLimit, globFirstMatch(glob))
+		if ref == "self[0].contents.trim()" {
+			script += ` | sed -e 's/^[[:space:]]*//' -e 's/[[:space:]]*$//'`
+		}
+		return script, nil
+	case "self[0].basename":
+		return fmt.Sprintf("basename %s", globFirstMatch(glob)), nil
+	case "self[0].path", "self[0].location":
+		return fmt.Sprintf("readlink -f %s", globFirstMatch(glob)), nil
+	case "self[0].size":
+		return fmt.Sprintf("wc -c < %s | tr -d ' '", globFirstMatch(glob)), nil
+	case "self.length":
+		return fmt.Sprintf("%s | wc -l | tr -d ' '", globMatches(glob)), nil
+	}
+	return "", fmt.Errorf("outputEval $(%s) is not supported", ref)
+}
+
+// outputValueScript returns a shell pipeline printing the value of an output
+// binding, after globbing, loadContents and outputEval have been applied.
+func outputValueScript(binding *cwl.CommandlineOutputBinding, glob string) (string, error) {
+	loadContents := binding.LoadContents != nil && *binding.LoadContents
+	eval := binding.OutputEval
+
+	switch eval.Kind {
+	case cwl.ExpressionKind:
+		ref, err := parameterReference(eval)
+		if err != nil {
+			return "", err
+		}
+		return selfReferenceScript(ref, glob, loadContents)
+	case cwl.IntKind:
+		return fmt.Sprintf("printf '%%s' %d", eval.Int), nil
+	case cwl.FloatKind:
+		return fmt.Sprintf("printf '%%s' %v", eval.Float), nil
+	case cwl.BoolKind:
+		return fmt.Sprintf("printf '%%s' %t", eval.Bool), nil
+	}
+
+	if !eval.IsEmpty() {
+		return fmt.Sprintf("printf '%%s' %s", shellQuote(eval.Raw)), nil
+	}
+	if glob == "" {
+		return "", errors.New("glob or outputEval is required")
+	}
+	return fmt.Sprintf("cat %s", globFirstMatch(glob)), nil
+}
+
+// recordValueScript returns a shell snippet printing a record output as a
+// JSON object, one key per record field. Optional fields without an
+// outputBinding are null.
+func recordValueScript(record *cwl.CommandlineOutputRecordSchema) (string, error) {
+	parts := make([]string, 0)
+	parts = append(parts, "printf '{'")
+	for i, field := range record.Fields {
+		if i > 0 {
+			parts = append(parts, "printf ','")
+		}
+		parts = append(parts, fmt.Sprintf("printf '%%s:' %s", shellQuote(fmt.Sprintf("%q", field.Name))))
+
+		if field.OutputBinding == nil {
+			if !cwl.IsOptional(field.Type) {
+				return "", fmt.Errorf("outputBinding required for record field %s", field.Name)
+			}
+			parts = append(parts, "printf 'null'")
+			continue
+		}
+		if len(field.Type) != 1 {
+			return "", fmt.Errorf("only single field types expected in record field %s", field.Name)
+		}
+		globs, err := evalCommandlineBindingOutputGlob(&field.OutputBinding.Glob)
+		if err != nil {
+			return "", err
+		}
+		value, err := outputValueScript(field.OutputBinding, globPattern(globs))
+		if err != nil {
+			return "", err
+		}
+		switch field.Type[0].Kind {
+		case cwl.CWLStringKind:
+			parts = append(parts, fmt.Sprintf(`proteus_json_string "$(%s)"`, value))
+		case cwl.CWLIntKind, cwl.CWLLongKind, cwl.CWLFloatKind, cwl.CWLDoubleKind, cwl.CWLBoolKind:
+			parts = append(parts, fmt.Sprintf(`printf '%%s' "$(%s | tr -d '[:space:]')"`, value))
+		default:
+			return "", fmt.Errorf("%v is not a supported record field type", field.Type[0].Kind)
+		}
+	}
+	parts = append(parts, "printf '}'")
+	return "{ " + strings.Join(parts, "; ") + "; }", nil
+}
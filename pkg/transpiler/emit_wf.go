@@ -1,12 +1,15 @@
 package transpiler
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
+	"sort"
 	"strings"
 	"time"
 
-	apiv1 "k8s.io/api/core/v1"
+	log "github.com/sirupsen/logrus"
 
 	"github.com/SerRichard/proteus/pkg/cwl"
 	"github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
@@ -25,14 +28,30 @@ func RandomString(length int) string {
 	return string(b)
 }
 
-func EmitWorkflowArguments(inputs *cwl.WorkflowInputs) (*v1alpha1.Arguments, error) {
+// EmitWorkflowArguments emits the workflow inputs as arguments of the
+// workflow. File and Directory inputs with a location are passed as artifacts.
+func EmitWorkflowArguments(inputs *cwl.WorkflowInputs, locations cwl.FileLocations) (*v1alpha1.Arguments, error) {
 
 	var args v1alpha1.Arguments
 
-	for key, input := range *inputs {
+	keys := make([]string, 0)
+	for key := range *inputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		input := (*inputs)[key]
 		var tmpParam v1alpha1.Parameter
 		tmpParam.Name = key
 
+		if location, ok := locations.Inputs[key]; ok && (cwl.IsAllFiles(input.Type) || cwl.IsAllDirectories(input.Type)) {
+			art := v1alpha1.Artifact{Name: key}
+			art.ArtifactLocation = location.ArtifactLocation()
+			args.Artifacts = append(args.Artifacts, art)
+			continue
+		}
+
 		for _, _type := range input.Type {
 			switch _type.Kind {
 			case cwl.CWLStringKind:
@@ -49,7 +68,159 @@ func EmitWorkflowArguments(inputs *cwl.WorkflowInputs) (*v1alpha1.Arguments, err
 	return &args, nil
 }
 
-func EmitStepInput(input *cwl.WorkflowStepInput, default_name string) (*v1alpha1.Parameter, error) {
+// splitSource splits a step input source into the step it refers to and the
+// output of that step. Sources without a step refer to workflow inputs, a
+// leading # is the fragment form of the same source.
+func splitSource(source string) (string, string) {
+	scope, key, found := strings.Cut(strings.TrimPrefix(source, "#"), "/")
+	if !found {
+		return globalScope, source
+	}
+	return scope, key
+}
+
+func argoStepName(id string) string {
+	return strings.ReplaceAll(id, "_", "-")
+}
+
+// sourceParameterReference references the parameter value of a source.
+func sourceParameterReference(source string) string {
+	scope, key := splitSource(source)
+	if scope == globalScope {
+		return fmt.Sprintf("{{workflow.parameters.%s}}", key)
+	}
+	return fmt.Sprintf("{{steps.%s.outputs.parameters.%s}}", argoStepName(scope), key)
+}
+
+// sourceArtifactReference references the artifact of a source. Workflow inputs
+// come from the entrypoint inputs, which requires their location to be
+// configured, step outputs from the producing step.
+func sourceArtifactReference(source string, locations cwl.FileLocations) (string, error) {
+	scope, key := splitSource(source)
+	if scope == globalScope {
+		if _, ok := locations.Inputs[key]; !ok {
+			return "", fmt.Errorf("location data not present for %s", key)
+		}
+		return fmt.Sprintf("{{inputs.artifacts.%s}}", key), nil
+	}
+	return fmt.Sprintf("{{steps.%s.outputs.artifacts.%s}}", argoStepName(scope), key), nil
+}
+
+// isMergedInput reports whether the sources of a step input are merged into
+// an array, which is the case for several sources or an explicit linkMerge.
+func isMergedInput(input *cwl.WorkflowStepInput) bool {
+	return len(input.Source) > 1 || input.LinkMerge != nil
+}
+
+// isBoundInput reports whether a step input provides a value, inputs without
+// source, default or valueFrom leave the tool input unbound.
+func isBoundInput(input *cwl.WorkflowStepInput) bool {
+	return len(input.Source) > 0 || input.Default != nil || input.ValueFrom != nil
+}
+
+// quoteExpression quotes s as a string literal of an argo expression.
+func quoteExpression(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// stepInputExpression returns an argo expression of the value of a step input
+// once its sources are merged and its default applied, empty values are
+// treated as null. It returns "" for inputs without source and default.
+func stepInputExpression(workflow *cwl.Workflow, input *cwl.WorkflowStepInput) string {
+	var expr string
+	if len(input.Source) > 0 {
+		if isMergedInput(input) {
+			expr = fmt.Sprintf("toJson(%s)", mergeSourcesExpression(workflow, input.Source, input.LinkMerge))
+		} else {
+			expr = sourceExpressionReference(input.Source[0])
+		}
+	}
+
+	if input.Default != nil {
+		if expr == "" {
+			return quoteExpression(*input.Default)
+		}
+		expr = fmt.Sprintf("sprig.default(%s, %s)", quoteExpression(*input.Default), expr)
+	}
+	return expr
+}
+
+// valueFromTemplate translates the valueFrom of a step input into an argo
+// expression template. Parameter references to self are bound to the value
+// of the source, references to inputs to the other step inputs before their
+// own valueFrom is applied.
+func valueFromTemplate(workflow *cwl.Workflow, input *cwl.WorkflowStepInput, inputs map[string]cwl.WorkflowStepInput) (string, error) {
+	var text string
+	switch input.ValueFrom.Kind {
+	case cwl.ExpressionKind:
+		text = input.ValueFrom.Expression
+	case cwl.IntKind:
+		return fmt.Sprint(input.ValueFrom.Int), nil
+	case cwl.FloatKind:
+		return fmt.Sprint(input.ValueFrom.Float), nil
+	case cwl.BoolKind:
+		return fmt.Sprint(input.ValueFrom.Bool), nil
+	default:
+		text = input.ValueFrom.Raw
+	}
+
+	if strings.HasPrefix(text, "${") {
+		return "", fmt.Errorf("javascript expression %s is not supported", text)
+	}
+
+	var out strings.Builder
+	for {
+		start := strings.Index(text, "$(")
+		if start == -1 {
+			out.WriteString(text)
+			break
+		}
+		out.WriteString(text[:start])
+
+		depth := 0
+		end := -1
+		for i := start + 1; i < len(text); i++ {
+			if text[i] == '(' {
+				depth++
+			} else if text[i] == ')' {
+				depth--
+				if depth == 0 {
+					end = i
+					break
+				}
+			}
+		}
+		if end == -1 {
+			return "", fmt.Errorf("unterminated parameter reference in %s", text)
+		}
+
+		ref := strings.TrimSpace(text[start+2 : end])
+		var expr string
+		switch {
+		case ref == "self":
+			expr = stepInputExpression(workflow, input)
+			if expr == "" {
+				expr = "''"
+			}
+		case strings.HasPrefix(ref, "inputs."):
+			other, ok := inputs[strings.TrimPrefix(ref, "inputs.")]
+			if !ok {
+				return "", fmt.Errorf("%s is not an input of the step", ref)
+			}
+			expr = stepInputExpression(workflow, &other)
+			if expr == "" {
+				expr = "''"
+			}
+		default:
+			return "", fmt.Errorf("valueFrom $(%s) is not supported", ref)
+		}
+		out.WriteString(fmt.Sprintf("{{=%s}}", expr))
+		text = text[end+1:]
+	}
+	return out.String(), nil
+}
+
+func EmitStepInput(workflow *cwl.Workflow, input *cwl.WorkflowStepInput, default_name string, inputs map[string]cwl.WorkflowStepInput) (*v1alpha1.Parameter, error) {
 
 	var paramName string
 	if input.Id == nil {
@@ -58,19 +229,26 @@ func EmitStepInput(input *cwl.WorkflowStepInput, default_name string) (*v1alpha1
 		paramName = *input.Id
 	}
 
-	var stringList []string = strings.Split(*input.Source, "/")
-	var inputReference string
-
-	var scope string = stringList[0]
-	var key string = stringList[1]
-
-	// If a global input, we will use workflow.parameters... else steps.{{stepId}}.outputs.parameters.{{param.Name}}
-	if scope == "global" {
-		inputReference = fmt.Sprintf("{{workflow.parameters.%s}}", key)
-	} else {
-		var updatedScope string = strings.ReplaceAll(scope, "_", "-")
+	if !isBoundInput(input) {
+		return nil, fmt.Errorf("source, default or valueFrom required for %s", paramName)
+	}
 
-		inputReference = fmt.Sprintf("{{steps.%s.outputs.parameters.%s}}", updatedScope, key)
+	var inputReference string
+	var err error
+	switch {
+	case input.ValueFrom != nil:
+		inputReference, err = valueFromTemplate(workflow, input, inputs)
+		if err != nil {
+			return nil, err
+		}
+	case len(input.Source) == 1 && !isMergedInput(input) && input.Default == nil:
+		// If a global input, we will use workflow.parameters... else steps.{{stepId}}.outputs.parameters.{{param.Name}}
+		inputReference = sourceParameterReference(input.Source[0])
+	case len(input.Source) == 0:
+		inputReference = *input.Default
+	default:
+		// Merged sources are aggregated into a JSON array
+		inputReference = fmt.Sprintf("{{=%s}}", stepInputExpression(workflow, input))
 	}
 
 	var returnParam v1alpha1.Parameter
@@ -80,6 +258,42 @@ func EmitStepInput(input *cwl.WorkflowStepInput, default_name string) (*v1alpha1
 	return &returnParam, nil
 }
 
+// EmitStepInputArtifacts references the Files or Directories bound to a step
+// input and declares where the step template stages them. Merged sources are
+// staged as one artifact each, below the path of the input.
+func EmitStepInputArtifacts(input *cwl.WorkflowStepInput, name string, path string, locations cwl.FileLocations) ([]v1alpha1.Artifact, []v1alpha1.Artifact, error) {
+	if len(input.Source) == 0 {
+		return nil, nil, fmt.Errorf("source required for %s", name)
+	}
+	if input.Default != nil || input.ValueFrom != nil {
+		return nil, nil, fmt.Errorf("default and valueFrom are not supported for the File input %s", name)
+	}
+
+	args := make([]v1alpha1.Artifact, 0)
+	inputs := make([]v1alpha1.Artifact, 0)
+
+	if !isMergedInput(input) {
+		from, err := sourceArtifactReference(input.Source[0], locations)
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, v1alpha1.Artifact{Name: name, From: from})
+		inputs = append(inputs, v1alpha1.Artifact{Name: name, Path: path})
+		return args, inputs, nil
+	}
+
+	for i, source := range input.Source {
+		from, err := sourceArtifactReference(source, locations)
+		if err != nil {
+			return nil, nil, err
+		}
+		artName := mergedItemName(name, i)
+		args = append(args, v1alpha1.Artifact{Name: artName, From: from})
+		inputs = append(inputs, v1alpha1.Artifact{Name: artName, Path: fmt.Sprintf("%s/%d", path, i)})
+	}
+	return args, inputs, nil
+}
+
 func cleanArgs(s string) (string, error) {
 
 	startIndex := strings.Index(s, "$(")
@@ -105,137 +319,369 @@ func cleanArgs(s string) (string, error) {
 	return cleanArgs(updatedString)
 }
 
-// Need to change the input ref syntax from cwl to argo
-func sanitizeArgs(arguments *cwl.Arguments) (*[]string, error) {
+// stepInputPath is where a File input of a step tool is staged.
+func stepInputPath(id string) string {
+	return fmt.Sprintf("%s/%s", inputsStagingPath, id)
+}
 
-	var returnArgs []string
-	for _, arg := range *arguments {
+// flattenStepInput binds the inputs of the tool run by a step. Values are
+// provided through the step arguments, so only the binding data is kept.
+func flattenStepInput(run *cwl.WorkflowCommandLineTool) ([]flatCommandlineInputParameter, error) {
+	flatInputs := make([]flatCommandlineInputParameter, 0)
+	for _, input := range run.Inputs {
+		if input.ID == nil {
+			return nil, errors.New("input parameter is nil")
+		}
+		if len(input.Type) == 0 {
+			return nil, fmt.Errorf("type expected for %s", *input.ID)
+		}
 
-		cleanArg, err := cleanArgs(arg)
-		if err != nil {
-			return nil, err
+		binding := flatCommandlineInputParameter{
+			Type:           input.Type[0].Kind,
+			SecondaryFiles: input.SecondaryFiles,
+			Streamable:     input.Streamable,
+			Doc:            input.Doc,
+			Id:             input.ID,
+			Format:         input.Format,
+			InputBinding:   input.InputBinding,
+			Array:          input.Type[0].Array,
+			Emit:           true,
 		}
-		returnArgs = append(returnArgs, cleanArg)
-	}
 
-	return &returnArgs, nil
+		if isArtifactType(binding.Type) {
+			path := stepInputPath(*input.ID)
+			binding.File = &cwl.CWLFile{Class: "File", Path: &path}
+		}
+		flatInputs = append(flatInputs, binding)
+	}
+	return flatInputs, nil
 }
 
-func EmitCommandArgs(container *apiv1.Container, run *cwl.WorkflowCommandLineTool) error {
-	tmpContainer := container.DeepCopy()
+// omitUnboundOptionalInputs drops the optional tool inputs a step leaves
+// unbound and which have no default, they are null and neither passed to the
+// step nor added to the command line.
+func omitUnboundOptionalInputs(step *cwl.WorkflowStep, bindings []flatCommandlineInputParameter) {
+	_, inputs := orderedStepInputs(step)
+	for idx, binding := range bindings {
+		input, ok := inputs[*binding.Id]
+		if ok && isBoundInput(&input) {
+			continue
+		}
+		toolInput := findToolInput(&step.Run, *binding.Id)
+		if toolInput == nil || toolInput.Default != nil || !cwl.IsOptional(toolInput.Type) {
+			continue
+		}
+		bindings[idx].Type = cwl.CWLNullKind
+		bindings[idx].File = nil
+	}
+}
 
-	tmpContainer.Command = run.BaseCommand
+// orderedStepInputs returns the step inputs keyed by the id they bind to.
+func orderedStepInputs(step *cwl.WorkflowStep) ([]string, map[string]cwl.WorkflowStepInput) {
+	ids := make([]string, 0)
+	inputs := make(map[string]cwl.WorkflowStepInput)
 
-	cleanArgs, err := sanitizeArgs(&run.Arguments)
+	if step.In.Array != nil {
+		for idx, input := range step.In.Array {
+			var id string = "step-" + fmt.Sprint(idx)
+			if input.Id != nil {
+				id = *input.Id
+			}
+			ids = append(ids, id)
+			inputs[id] = input
+		}
+	} else if step.In.Map != nil {
+		for key, input := range step.In.Map {
+			ids = append(ids, key)
+			inputs[key] = input
+		}
+		sort.Strings(ids)
+	}
+	return ids, inputs
+}
 
-	if err != nil {
-		return err
+// stageMergedFiles sets the staged paths of File arrays merged from several
+// step sources, each source is staged as a single File.
+func stageMergedFiles(step *cwl.WorkflowStep, bindings []flatCommandlineInputParameter) {
+	_, inputs := orderedStepInputs(step)
+	for idx, binding := range bindings {
+		input, ok := inputs[*binding.Id]
+		if !ok || !isMergedInput(&input) || !isArtifactArray(binding) {
+			continue
+		}
+		paths := make([]string, 0)
+		for i := range input.Source {
+			paths = append(paths, fmt.Sprintf("%s/%d", stepInputPath(*binding.Id), i))
+		}
+		bindings[idx].Paths = paths
 	}
-	tmpContainer.Args = *cleanArgs
+}
 
-	*container = *tmpContainer
+// expandMergedParameters sets the parameters of the items of arrays merged
+// from several step sources, each source is passed as a single item so the
+// items are added to the command line one by one.
+func expandMergedParameters(workflow *cwl.Workflow, step *cwl.WorkflowStep, bindings []flatCommandlineInputParameter) error {
+	_, inputs := orderedStepInputs(step)
+	for idx, binding := range bindings {
+		input, ok := inputs[*binding.Id]
+		if !ok || !isMergedInput(&input) || binding.Type != cwl.CWLArrayKind || isArtifactArray(binding) || binding.InputBinding == nil {
+			continue
+		}
+		if input.ValueFrom != nil {
+			return fmt.Errorf("merged input %s with valueFrom can not be added to the command line", *binding.Id)
+		}
 
+		items := make([]string, 0)
+		for i, source := range input.Source {
+			types := sourceTypes(workflow, source)
+			if len(types) > 0 && types[0].Kind == cwl.CWLArrayKind {
+				return fmt.Errorf("merged input %s has the array source %s, its items can not be added to the command line", *binding.Id, source)
+			}
+			items = append(items, fmt.Sprintf("{{inputs.parameters.%s}}", mergedItemName(*binding.Id, i)))
+		}
+		bindings[idx].Items = items
+	}
 	return nil
 }
 
-func EmitStep(step *cwl.WorkflowStep, locations cwl.FileLocations, outputs v1alpha1.Outputs) (*v1alpha1.WorkflowStep, error) {
-	outStep := v1alpha1.WorkflowStep{}
-	template := v1alpha1.Template{}
-	container := apiv1.Container{}
+// mergedItemName names the parameter or artifact of an item of a merged input.
+func mergedItemName(id string, i int) string {
+	return fmt.Sprintf("%s-%d", id, i)
+}
 
-	outStep.Name = strings.Replace(step.Id, "_", "-", -1)
+// emitStepInputVerification checks the Files of the job which a step stages
+// directly from a workflow input against their declared checksum and size.
+func emitStepInputVerification(wrapper *commandWrapper, step *cwl.WorkflowStep, bindings []flatCommandlineInputParameter, inputs map[string]cwl.CWLInputEntry) error {
+	_, stepInputs := orderedStepInputs(step)
+	for _, binding := range bindings {
+		if binding.Type != cwl.CWLFileKind {
+			continue
+		}
+		input, ok := stepInputs[*binding.Id]
+		if !ok || len(input.Source) == 0 || isMergedInput(&input) {
+			continue
+		}
+		scope, key := splitSource(strings.TrimPrefix(input.Source[0], "#"))
+		entry, ok := inputs[key]
+		if scope != globalScope || !ok || entry.Kind != cwl.CWLFileKind {
+			continue
+		}
 
-	dockerRequirement, err := findDockerRequirement(step.Requirements)
-	if err != nil {
-		return nil, err
+		err := emitFileVerification(wrapper, key, stepInputPath(*binding.Id), entry.FileData)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	err = emitDockerRequirement(&container, dockerRequirement)
-	if err != nil {
-		return nil, err
-	}
+// emitStepArguments binds the step `in` values to the inputs of the tool by
+// id. Tool inputs the step does not bind fall back to the tool default, those
+// without a default must be optional. File
+// and Directory inputs are staged as artifacts where the tool expects them,
+// other arrays are passed as JSON encoded parameters, along with their items
+// when they are merged from several sources.
+func emitStepArguments(workflow *cwl.Workflow, step *cwl.WorkflowStep, template *v1alpha1.Template, bindings []flatCommandlineInputParameter, locations cwl.FileLocations) (*v1alpha1.Arguments, error) {
+	args := v1alpha1.Arguments{}
+
+	_, inputs := orderedStepInputs(step)
+
+	for _, binding := range bindings {
+		if binding.Type == cwl.CWLArrayKind && binding.Paths == nil && !isArtifactArray(binding) {
+			template.Inputs.Parameters = append(template.Inputs.Parameters, v1alpha1.Parameter{Name: *binding.Id})
+			continue
+		}
+		if !isArtifactType(binding.Type) && binding.Paths == nil {
+			continue
+		}
+		input, ok := inputs[*binding.Id]
+		if !ok {
+			return nil, fmt.Errorf("required input %s is not bound", *binding.Id)
+		}
 
-	err = EmitCommandArgs(&container, &step.Run)
-	if err != nil {
-		return nil, err
+		arts, inputArts, err := EmitStepInputArtifacts(&input, *binding.Id, stepInputPath(*binding.Id), locations)
+		if err != nil {
+			return nil, err
+		}
+		args.Artifacts = append(args.Artifacts, arts...)
+		template.Inputs.Artifacts = append(template.Inputs.Artifacts, inputArts...)
 	}
 
-	template.Container = &container
-	var templateInputs []v1alpha1.Parameter
+	for idx, param := range template.Inputs.Parameters {
+		input, ok := inputs[param.Name]
+		if !ok || !isBoundInput(&input) {
+			toolDefault := findToolDefault(&step.Run, param.Name)
+			if toolDefault == nil {
+				return nil, fmt.Errorf("required input %s is not bound", param.Name)
+			}
+			template.Inputs.Parameters[idx].Default = (*v1alpha1.AnyString)(toolDefault)
+			continue
+		}
 
-	// A step input can either be a workflow argument, or the output of another step!
-	if step.In.Array != nil {
-		for idx, input := range step.In.Array {
-			var stepName string = "step-" + fmt.Sprint(idx)
+		newParam, err := EmitStepInput(workflow, &input, param.Name, inputs)
+		if err != nil {
+			return nil, err
+		}
+		args.Parameters = append(args.Parameters, *newParam)
+	}
 
-			newInput, err := EmitStepInput(&input, stepName)
+	// The items of merged arrays are passed one by one for the command line
+	for _, binding := range bindings {
+		if binding.Items == nil {
+			continue
+		}
+		for i, source := range inputs[*binding.Id].Source {
+			name := mergedItemName(*binding.Id, i)
+			reference := sourceParameterReference(source)
+			template.Inputs.Parameters = append(template.Inputs.Parameters, v1alpha1.Parameter{Name: name})
+			args.Parameters = append(args.Parameters, v1alpha1.Parameter{Name: name, Value: (*v1alpha1.AnyString)(&reference)})
+		}
+	}
+	return &args, nil
+}
 
-			if err != nil {
-				return nil, err
+// findToolDefault returns the default of a tool input as a parameter value.
+func findToolDefault(run *cwl.WorkflowCommandLineTool, id string) *string {
+	for _, input := range run.Inputs {
+		if input.ID == nil || *input.ID != id || input.Default == nil {
+			continue
+		}
+		value := fmt.Sprint(input.Default)
+		switch input.Default.(type) {
+		case []interface{}, map[string]interface{}:
+			data, err := json.Marshal(input.Default)
+			if err == nil {
+				value = string(data)
 			}
-			templateInputs = append(templateInputs, *newInput)
 		}
-	} else if step.In.Map != nil {
-		for key, input := range step.In.Map {
+		return &value
+	}
+	return nil
+}
 
-			newInput, err := EmitStepInput(&input, key)
-			if err != nil {
-				return nil, err
-			}
-			templateInputs = append(templateInputs, *newInput)
+func findToolInput(run *cwl.WorkflowCommandLineTool, id string) *cwl.CommandlineInputParameter {
+	for _, input := range run.Inputs {
+		if input.ID != nil && *input.ID == id {
+			return &input
 		}
 	}
+	return nil
+}
 
-	// Add the parsed argo outputs into the template if they are relevant!
-	for _, output := range step.Out {
-		for _, argoOutput := range outputs.Parameters {
-			if argoOutput.Name == *output.Id {
-				template.Outputs.Parameters = append(template.Outputs.Parameters, argoOutput)
-			}
+func findToolOutput(run *cwl.WorkflowCommandLineTool, id string) *cwl.CommandlineOutputParameter {
+	for _, output := range run.Outputs {
+		if output.ID != nil && *output.ID == id {
+			return &output
 		}
 	}
+	return nil
+}
 
-	template.Inputs.Parameters = templateInputs
+// fileOutputsReadAsParameters returns the File outputs of a step which other
+// steps bind to inputs that are not Files, those are read back as parameters.
+func fileOutputsReadAsParameters(workflow *cwl.Workflow, producer *cwl.WorkflowStep) map[string]bool {
+	outputs := make(map[string]bool)
+	for _, consumer := range workflow.Steps {
+		_, inputs := orderedStepInputs(&consumer)
+		for id, input := range inputs {
+			toolInput := findToolInput(&consumer.Run, id)
+			if toolInput == nil || len(toolInput.Type) == 0 || isArtifactType(toolInput.Type[0].Kind) || cwl.IsAllFiles(toolInput.Type) {
+				continue
+			}
+			for _, source := range input.Source {
+				scope, key := splitSource(source)
+				if scope == producer.Id {
+					outputs[key] = true
+				}
+			}
+		}
+	}
+	return outputs
+}
 
-	outStep.Inline = &template
+// emitFileOutputParameter exposes the contents of a File output as a parameter.
+func emitFileOutputParameter(template *v1alpha1.Template, run *cwl.WorkflowCommandLineTool, id string) error {
+	output := findToolOutput(run, id)
+	if output == nil || output.OutputBinding == nil {
+		return fmt.Errorf("outputBinding required for %s", id)
+	}
+	globs, err := evalCommandlineBindingOutputGlob(&output.OutputBinding.Glob)
+	if err != nil {
+		return err
+	}
+	if !isLiteralGlob(globs) {
+		return fmt.Errorf("%s is read as a parameter and requires a single glob without wildcards", id)
+	}
 
-	return &outStep, nil
+	log.Warnf("File output %s is bound to a non File input, its contents are passed as a parameter", id)
+	param := v1alpha1.Parameter{Name: id, ValueFrom: &v1alpha1.ValueFrom{Path: resolveOutputPath(template.Container, globs[0])}}
+	template.Outputs.Parameters = append(template.Outputs.Parameters, param)
+	return nil
 }
 
-func emitWorkflowStepOutputs(workflow *cwl.Workflow) (*v1alpha1.Outputs, error) {
-
-	var stepOutputs v1alpha1.Outputs
+func EmitStep(step *cwl.WorkflowStep, inputs map[string]cwl.CWLInputEntry, locations cwl.FileLocations, workflow *cwl.Workflow, spec *v1alpha1.WorkflowSpec) (*v1alpha1.WorkflowStep, error) {
+	outStep := v1alpha1.WorkflowStep{}
 
-	// Get output resources from the steps
-	for _, step := range workflow.Steps {
-		// Get the step run resource
+	outStep.Name = argoStepName(step.Id)
 
-		// For every output, add a tmp parameter and check the step run for the output value from
-		for _, out := range step.Out {
-			var tmpParameter v1alpha1.Parameter
+	bindings, err := flattenStepInput(&step.Run)
+	if err != nil {
+		return nil, err
+	}
+	omitUnboundOptionalInputs(step, bindings)
+	stageMergedFiles(step, bindings)
+	err = expandMergedParameters(workflow, step, bindings)
+	if err != nil {
+		return nil, err
+	}
 
-			tmpParameter.Name = *out.Id
+	// Requirements and hints are inherited from the workflow and the step, the
+	// tool takes precedence. Hints only apply where no requirement overrides them
+	requirements := cwl.ApplyHints(
+		cwl.InheritRequirements(workflow.Requirements, step.Requirements, step.Run.Requirements),
+		cwl.InheritHints(workflow.Hints, step.Hints, step.Run.Hints),
+	)
 
-			for _, output := range step.Run.Outputs {
+	// Step outputs are passed on to other steps as artifacts, only those exposed
+	// as workflow outputs are written to the configured locations
+	template, _, wrapper, err := emitCommandlineTemplate(&step.Run.CommandLineTool, requirements, bindings, stepOutputLocations(workflow, step, locations), true)
+	if err != nil {
+		return nil, err
+	}
 
-				if *output.ID == tmpParameter.Name {
-					var tmpValueFrom v1alpha1.ValueFrom
-					tmpValueFrom.Path = *output.OutputBinding.Glob.String
-					tmpParameter.ValueFrom = &tmpValueFrom
+	err = emitStepInputVerification(wrapper, step, bindings, inputs)
+	if err != nil {
+		return nil, err
+	}
+	applyCommandWrapper(template.Container, wrapper)
+	template.Name = ""
 
-				}
-			}
+	// Pod settings of the workflow are already the defaults of the whole workflow
+	podRequirements := cwl.ApplyHints(
+		cwl.InheritRequirements(step.Requirements, step.Run.Requirements),
+		cwl.InheritHints(step.Hints, step.Run.Hints),
+	)
+	emitPodSettings(template, spec, findKubernetesPod(podRequirements))
 
-			stepOutputs.Parameters = append(stepOutputs.Parameters, tmpParameter)
+	args, err := emitStepArguments(workflow, step, template, bindings, locations)
+	if err != nil {
+		return nil, err
+	}
+	outStep.Arguments = *args
 
+	for id := range fileOutputsReadAsParameters(workflow, step) {
+		err := emitFileOutputParameter(template, &step.Run, id)
+		if err != nil {
+			return nil, err
 		}
-
 	}
 
-	return &stepOutputs, nil
+	outStep.Inline = template
+
+	return &outStep, nil
 }
 
-func EmitWorkflow(workflow *cwl.Workflow, inputs map[string]cwl.CWLInputEntry, locations cwl.FileLocations) (*v1alpha1.Workflow, error) {
+func EmitWorkflow(workflow *cwl.Workflow, inputs map[string]cwl.CWLInputEntry, locations cwl.FileLocations, volume VolumeOptions) (*v1alpha1.Workflow, error) {
 	var wf v1alpha1.Workflow
 
 	var workflowTemplate v1alpha1.Template
@@ -252,16 +698,27 @@ func EmitWorkflow(workflow *cwl.Workflow, inputs map[string]cwl.CWLInputEntry, l
 
 	spec := v1alpha1.WorkflowSpec{}
 
-	args, err := EmitWorkflowArguments(&workflow.Inputs)
+	args, err := EmitWorkflowArguments(&workflow.Inputs, locations)
 	if err != nil {
 		return nil, err
 	}
 	spec.Arguments = *args
 
-	// Get the workflow outputs.
-	workflowOutputs, err := emitWorkflowStepOutputs(workflow)
-	if err != nil {
-		return nil, err
+	emitWorkflowPodDefaults(&spec, findKubernetesPod(cwl.ApplyHints(workflow.Requirements, workflow.Hints)))
+
+	// Artifact arguments are passed to the entrypoint, which hands them to the steps
+	for _, art := range args.Artifacts {
+		workflowTemplate.Inputs.Artifacts = append(workflowTemplate.Inputs.Artifacts, v1alpha1.Artifact{Name: art.Name})
+	}
+
+	// Steps hand their outputs over as artifacts, a volume is only shared
+	// between them when a strategy asks for one
+	shareVolume := needsVolume(volume, false)
+	if shareVolume {
+		err = emitWorkflowVolume(&spec, volume, workflow)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// For every step in the workflow, we create a ParrallelStep
@@ -269,9 +726,12 @@ func EmitWorkflow(workflow *cwl.Workflow, inputs map[string]cwl.CWLInputEntry, l
 	for _, step := range workflow.Steps {
 		var tmpParralel v1alpha1.ParallelSteps
 
-		tmp, err := EmitStep(&step, locations, *workflowOutputs)
+		tmp, err := EmitStep(&step, inputs, locations, workflow, &spec)
 
 		if err == nil {
+			if shareVolume {
+				attachVolume(tmp.Inline.Container, volumeClaimName, volumeClaimMountPath)
+			}
 			tmpParralel.Steps = append(tmpParralel.Steps, *tmp)
 
 		} else {
@@ -281,9 +741,18 @@ func EmitWorkflow(workflow *cwl.Workflow, inputs map[string]cwl.CWLInputEntry, l
 		outSteps = append(outSteps, tmpParralel)
 	}
 
+	emitArtifactRepositoryRef(&spec, locations)
+	emitArtifactGC(&spec, locations)
+
 	workflowTemplate.Name = "global-template"
 	workflowTemplate.Steps = outSteps
 
+	outputs, err := EmitWorkflowOutputs(workflow, locations)
+	if err != nil {
+		return nil, err
+	}
+	workflowTemplate.Outputs = *outputs
+
 	spec.Entrypoint = workflowTemplate.Name
 	spec.Templates = append(spec.Templates, workflowTemplate)
 
@@ -291,3 +760,251 @@ func EmitWorkflow(workflow *cwl.Workflow, inputs map[string]cwl.CWLInputEntry, l
 
 	return &wf, nil
 }
+
+// workflowOutputIds returns the ids of the workflow outputs in a stable order.
+func workflowOutputIds(workflow *cwl.Workflow) []string {
+	ids := make([]string, 0)
+	for id := range workflow.Outputs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// stepOutputLocations returns the locations the outputs of a step are written
+// to, which are those configured for the workflow outputs they are the source of.
+func stepOutputLocations(workflow *cwl.Workflow, step *cwl.WorkflowStep, locations cwl.FileLocations) cwl.FileLocations {
+	stepLocations := cwl.FileLocations{Outputs: make(map[string]cwl.FileLocationData), Artifacts: locations.Artifacts}
+	for _, id := range workflowOutputIds(workflow) {
+		location, ok := locations.Outputs[id]
+		if !ok && locations.ArtifactRepository != nil && isWorkflowArtifactOutput(workflow.Outputs[id]) {
+			location, ok = cwl.KeyOnlyLocation(id, repositoryOutputKey(id)), true
+		}
+		if !ok {
+			continue
+		}
+		for _, source := range workflow.Outputs[id].OutputSource {
+			scope, key := splitSource(strings.TrimPrefix(source, "#"))
+			if scope == step.Id {
+				stepLocations.Outputs[key] = location
+			}
+		}
+	}
+	return stepLocations
+}
+
+// isWorkflowArtifactOutput reports whether a workflow output is a File or
+// Directory, which are handed over as artifacts.
+func isWorkflowArtifactOutput(output cwl.WorkflowOutputParameter) bool {
+	return len(output.Type) > 0 && (cwl.IsAllFiles(output.Type) || cwl.IsAllDirectories(output.Type))
+}
+
+// sourceExpressionReference references the parameter value of a source in an
+// argo expression.
+func sourceExpressionReference(source string) string {
+	scope, key := splitSource(source)
+	if scope == globalScope {
+		return fmt.Sprintf("workflow.parameters['%s']", key)
+	}
+	return fmt.Sprintf("steps['%s'].outputs.parameters['%s']", argoStepName(scope), key)
+}
+
+// outputSourceExpression references the value of an output source in an argo
+// expression. Array values are JSON encoded parameters and are decoded so
+// they can be merged, numbers and booleans which can not be null are
+// converted so they keep their type once merged.
+func outputSourceExpression(workflow *cwl.Workflow, source string) string {
+	ref := sourceExpressionReference(source)
+	types := sourceTypes(workflow, source)
+	if len(types) == 0 {
+		return ref
+	}
+	if types[0].Kind == cwl.CWLArrayKind {
+		return fmt.Sprintf("jsonpath(%s, '$')", ref)
+	}
+	if cwl.IsOptional(types) {
+		return ref
+	}
+
+	switch types[0].Kind {
+	case cwl.CWLIntKind, cwl.CWLLongKind:
+		return fmt.Sprintf("asInt(%s)", ref)
+	case cwl.CWLFloatKind, cwl.CWLDoubleKind:
+		return fmt.Sprintf("asFloat(%s)", ref)
+	case cwl.CWLBoolKind:
+		return fmt.Sprintf("(%s == 'true')", ref)
+	}
+	return ref
+}
+
+// sourceTypes returns the types of the workflow input or of the step output a
+// source refers to.
+func sourceTypes(workflow *cwl.Workflow, source string) cwl.CWLTypes {
+	scope, key := splitSource(source)
+	if scope == globalScope {
+		return workflow.Inputs[key].Type
+	}
+	for _, step := range workflow.Steps {
+		if step.Id != scope {
+			continue
+		}
+		if output := findToolOutput(&step.Run, key); output != nil {
+			return output.Type
+		}
+	}
+	return nil
+}
+
+// mergeSourcesExpression returns an argo expression of the list the sources
+// are merged into. merge_nested, the default, keeps one item per source while
+// merge_flattened concatenates array sources.
+func mergeSourcesExpression(workflow *cwl.Workflow, sources []string, linkMerge *cwl.LinkMergeMethod) string {
+	refs := make([]string, 0)
+	for _, source := range sources {
+		refs = append(refs, outputSourceExpression(workflow, source))
+	}
+
+	if linkMerge == nil || *linkMerge == cwl.MergeNested {
+		return fmt.Sprintf("[%s]", strings.Join(refs, ", "))
+	}
+
+	lists := make([]string, 0)
+	for _, ref := range refs {
+		if strings.HasPrefix(ref, "jsonpath(") {
+			lists = append(lists, ref)
+		} else {
+			lists = append(lists, fmt.Sprintf("[%s]", ref))
+		}
+	}
+	values := lists[0]
+	for _, list := range lists[1:] {
+		values = fmt.Sprintf("sprig.concat(%s, %s)", values, list)
+	}
+	return values
+}
+
+// emitOutputParameterSources reads a workflow output parameter from its
+// sources. A single source is referenced directly, several sources are merged
+// with an argo expression following linkMerge then pickValue. Empty values
+// are treated as null when picking values.
+func emitOutputParameterSources(workflow *cwl.Workflow, output *cwl.WorkflowOutputParameter, sources []string) (*v1alpha1.Parameter, error) {
+	param := v1alpha1.Parameter{Name: *output.Id, ValueFrom: &v1alpha1.ValueFrom{}}
+
+	if len(sources) == 1 && output.PickValue == nil {
+		param.ValueFrom.Parameter = sourceParameterReference(sources[0])
+		return &param, nil
+	}
+
+	values := mergeSourcesExpression(workflow, sources, output.LinkMerge)
+
+	if output.PickValue == nil {
+		param.ValueFrom.Expression = fmt.Sprintf("toJson(%s)", values)
+		return &param, nil
+	}
+
+	nonNull := fmt.Sprintf("filter(%s, {# != ''})", values)
+	switch *output.PickValue {
+	case cwl.FirstNonNull:
+		param.ValueFrom.Expression = fmt.Sprintf("%s[0]", nonNull)
+	case cwl.AllNonNull:
+		param.ValueFrom.Expression = fmt.Sprintf("toJson(%s)", nonNull)
+	default:
+		return nil, pickValueNotSupported(output)
+	}
+	return &param, nil
+}
+
+// pickValueNotSupported rejects the pickValue methods which can not be
+// expressed in argo. the_only_non_null has to fail unless exactly one source
+// is non null, which an argo expression can not do.
+func pickValueNotSupported(output *cwl.WorkflowOutputParameter) error {
+	return fmt.Errorf("pickValue %s is not supported for the output %s", *output.PickValue, *output.Id)
+}
+
+// emitOutputArtifactSources hands a File or Directory workflow output over
+// from the step producing it. With pickValue the first step which succeeded
+// provides the artifact, otherwise several sources are exposed as one
+// artifact each, suffixed by their position.
+func emitOutputArtifactSources(output *cwl.WorkflowOutputParameter, sources []string, locations cwl.FileLocations) ([]v1alpha1.Artifact, error) {
+	artifacts := make([]v1alpha1.Artifact, 0)
+
+	if len(sources) == 1 && output.PickValue == nil {
+		ref, err := sourceArtifactReference(sources[0], locations)
+		if err != nil {
+			return nil, err
+		}
+		return append(artifacts, v1alpha1.Artifact{Name: *output.Id, From: ref}), nil
+	}
+
+	if output.PickValue != nil {
+		if *output.PickValue != cwl.FirstNonNull {
+			return nil, pickValueNotSupported(output)
+		}
+
+		var expression string
+		for i := len(sources) - 1; i >= 0; i-- {
+			scope, key := splitSource(sources[i])
+			if scope == globalScope {
+				return nil, fmt.Errorf("pickValue on workflow input %s is not supported for %s", key, *output.Id)
+			}
+			ref := fmt.Sprintf("steps['%s'].outputs.artifacts['%s']", argoStepName(scope), key)
+			if expression == "" {
+				expression = ref
+			} else {
+				expression = fmt.Sprintf("steps['%s'].status == 'Succeeded' ? %s : %s", argoStepName(scope), ref, expression)
+			}
+		}
+		return append(artifacts, v1alpha1.Artifact{Name: *output.Id, FromExpression: expression}), nil
+	}
+
+	for i, source := range sources {
+		ref, err := sourceArtifactReference(source, locations)
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, v1alpha1.Artifact{Name: fmt.Sprintf("%s-%d", *output.Id, i), From: ref})
+	}
+	return artifacts, nil
+}
+
+// EmitWorkflowOutputs exposes the workflow outputs on the entrypoint template.
+func EmitWorkflowOutputs(workflow *cwl.Workflow, locations cwl.FileLocations) (*v1alpha1.Outputs, error) {
+	outputs := v1alpha1.Outputs{}
+
+	for _, id := range workflowOutputIds(workflow) {
+		output := workflow.Outputs[id]
+		output.Id = &id
+
+		sources := make([]string, 0)
+		for _, source := range output.OutputSource {
+			sources = append(sources, strings.TrimPrefix(source, "#"))
+		}
+		if len(sources) == 0 {
+			return nil, fmt.Errorf("outputSource required for %s", id)
+		}
+
+		if isWorkflowArtifactOutput(output) {
+			if _, ok := locations.Outputs[id]; ok {
+				for _, source := range sources {
+					if scope, _ := splitSource(source); scope == globalScope {
+						return nil, fmt.Errorf("%s is a workflow input and can not be written to the location of %s", source, id)
+					}
+				}
+			}
+			artifacts, err := emitOutputArtifactSources(&output, sources, locations)
+			if err != nil {
+				return nil, err
+			}
+			outputs.Artifacts = append(outputs.Artifacts, artifacts...)
+			continue
+		}
+
+		param, err := emitOutputParameterSources(workflow, &output, sources)
+		if err != nil {
+			return nil, err
+		}
+		outputs.Parameters = append(outputs.Parameters, *param)
+	}
+
+	return &outputs, nil
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/SerRichard/proteus/pkg/cwl"
+	"github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/pretty"
 	"gopkg.in/yaml.v3"
@@ -21,7 +23,7 @@ func extractFileName(filename string, ext string) (string, error) {
 	return name, nil
 }
 
-func TranspileCommandlineTool(cl cwl.CommandLineTool, inputs map[string]cwl.CWLInputEntry, locations cwl.FileLocations, outputFile string) error {
+func TranspileCommandlineTool(cl cwl.CommandLineTool, inputs map[string]cwl.CWLInputEntry, locations cwl.FileLocations, volume VolumeOptions, outputFile string) error {
 
 	log.Infof("TypeCheckCommandlineTool")
 	err := cwl.TypeCheckCommandlineTool(&cl, inputs)
@@ -30,7 +32,7 @@ func TranspileCommandlineTool(cl cwl.CommandLineTool, inputs map[string]cwl.CWLI
 	}
 
 	log.Infof("EmitCommandlineTool")
-	wf, err := EmitCommandlineTool(&cl, inputs, locations)
+	wf, err := EmitCommandlineTool(&cl, inputs, locations, volume)
 	if err != nil {
 		return err
 	}
@@ -52,10 +54,10 @@ func TranspileCommandlineTool(cl cwl.CommandLineTool, inputs map[string]cwl.CWLI
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(outputFile, data, 0644)
+	return writeOutput(outputFile, data)
 }
 
-func TranspileCWLWorkflow(workflow cwl.Workflow, inputs map[string]cwl.CWLInputEntry, locations cwl.FileLocations, outputFile string) error {
+func TranspileCWLWorkflow(workflow cwl.Workflow, inputs map[string]cwl.CWLInputEntry, locations cwl.FileLocations, volume VolumeOptions, outputFile string) error {
 
 	// Check the workflow provided
 	err := cwl.TypeCheckWorkflow(&workflow, inputs)
@@ -64,7 +66,7 @@ func TranspileCWLWorkflow(workflow cwl.Workflow, inputs map[string]cwl.CWLInputE
 	}
 
 	// Convert the CWL Workflow to Argo Workflows
-	wf, err := EmitWorkflow(&workflow, inputs, locations)
+	wf, err := EmitWorkflow(&workflow, inputs, locations, volume)
 	if err != nil {
 		return err
 	}
@@ -86,47 +88,78 @@ func TranspileCWLWorkflow(workflow cwl.Workflow, inputs map[string]cwl.CWLInputE
 		return err
 	}
 
+	return writeOutput(outputFile, data)
+}
+
+func writeOutput(outputFile string, data []byte) error {
+	if outputFile == cwl.StdinPath {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
 	return os.WriteFile(outputFile, data, 0644)
 }
 
+// Options configure how a CWL document is processed.
+type Options struct {
+	// Entrypoint selects the process of a packed $graph document, #main when empty
+	Entrypoint string
+	// SearchPath holds directories searched for run documents which are not
+	// found relative to the document referencing them
+	SearchPath []string
+	// ArtifactRepository stores outputs without a location as key-only
+	// artifacts in the artifact repository and reads relative input locations
+	// as keys of it
+	ArtifactRepository bool
+	// ArtifactRepositoryRef names the artifact repository as configmap[/key],
+	// the default repository of the namespace when empty
+	ArtifactRepositoryRef string
+	// Volume configures the volume the output directory of the tools is on
+	Volume VolumeOptions
+	// Artifacts sets the archive and garbage collection of output artifacts,
+	// taking precedence over those of the locations file
+	Artifacts cwl.ArtifactOptions
+}
+
 func ProcessFile(inputFile string, inputsFile string, locationsFile string) error {
+	return ProcessFileWithOptions(inputFile, inputsFile, locationsFile, Options{})
+}
+
+func ProcessFileWithOptions(inputFile string, inputsFile string, locationsFile string, options Options) error {
 
 	log.Infof("Processing on CWL Version: %s ", cwl.CWLVersion)
 
+	err := options.Volume.Validate()
+	if err != nil {
+		return err
+	}
+
 	ext := filepath.Ext(inputFile)
 
-	var cwlData map[string]interface{}
 	var inputs map[string]cwl.CWLInputEntry
 	var fileLocations cwl.FileLocations
 
-	if ext != ".cwl" {
-		return fmt.Errorf("invalid file extension %s, only common workflow language (.cwl) files are allowed", ext)
-	}
-
-	name, err := extractFileName(inputFile, ext)
-	if err != nil {
-		log.Fatalf("%+v", err)
+	if inputFile == cwl.StdinPath && inputsFile == cwl.StdinPath {
+		return errors.New("only one of the CWL file and the inputs file can be read from stdin")
 	}
 
-	outputFile := fmt.Sprintf("%s_argo_output.yaml", name)
+	// Documents read from stdin are transpiled to stdout
+	outputFile := cwl.StdinPath
+	if inputFile != cwl.StdinPath {
+		name, err := extractFileName(inputFile, ext)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
 
-	def, err := os.ReadFile(inputFile)
-	if err != nil {
-		return err
+		outputFile = fmt.Sprintf("%s_argo_output.yaml", name)
 	}
 
-	err = yaml.Unmarshal(def, &cwlData)
+	root, err := cwl.LoadEntrypoint(inputFile, options.Entrypoint, options.SearchPath)
 	if err != nil {
 		return err
 	}
 
 	if inputsFile != "" {
-		data, err := os.ReadFile(inputsFile)
-		if err != nil {
-			return err
-		}
-
-		err = yaml.Unmarshal(data, &inputs)
+		inputs, err = cwl.LoadJobOrder(inputsFile)
 		if err != nil {
 			return err
 		}
@@ -144,9 +177,27 @@ func ProcessFile(inputFile string, inputsFile string, locationsFile string) erro
 		}
 	}
 
-	class, ok := cwlData["class"]
-	if !ok {
-		return errors.New("<class> expected")
+	if options.ArtifactRepository || options.ArtifactRepositoryRef != "" {
+		configMap, key, _ := strings.Cut(options.ArtifactRepositoryRef, "/")
+		fileLocations.ArtifactRepository = &v1alpha1.ArtifactRepositoryRef{ConfigMap: configMap, Key: key}
+	}
+
+	fileLocations.Artifacts = fileLocations.Artifacts.Override(options.Artifacts)
+
+	// Remote File and Directory locations of the job are artifacts themselves,
+	// the locations file only needs to hold credentials and overrides for them
+	fileLocations, err = cwl.InferInputLocations(inputs, fileLocations)
+	if err != nil {
+		return err
+	}
+	err = fileLocations.Validate()
+	if err != nil {
+		return err
+	}
+
+	class, err := cwl.DocumentClass(root)
+	if err != nil {
+		return err
 	}
 
 	if class == "CommandLineTool" {
@@ -155,25 +206,35 @@ func ProcessFile(inputFile string, inputsFile string, locationsFile string) erro
 
 		var cliTool cwl.CommandLineTool
 
-		err := yaml.Unmarshal(def, &cliTool)
+		err := root.Decode(&cliTool)
+		if err != nil {
+			return err
+		}
+
+		cliTool.Ontology, err = cwl.LoadSchemas(cliTool.Schemas, inputFile)
 		if err != nil {
 			return err
 		}
 
 		log.Infof("About to TypeCheckCommandlineTool")
 
-		return TranspileCommandlineTool(cliTool, inputs, fileLocations, outputFile)
+		return TranspileCommandlineTool(cliTool, inputs, fileLocations, options.Volume, outputFile)
 	} else if class == "Workflow" {
 
 		log.Infof("Found Workflow")
 		var workflow cwl.Workflow
 
-		err := yaml.Unmarshal(def, &workflow)
+		err := root.Decode(&workflow)
+		if err != nil {
+			return err
+		}
+
+		workflow.Ontology, err = cwl.LoadSchemas(workflow.Schemas, inputFile)
 		if err != nil {
 			return err
 		}
 
-		return TranspileCWLWorkflow(workflow, inputs, fileLocations, outputFile)
+		return TranspileCWLWorkflow(workflow, inputs, fileLocations, options.Volume, outputFile)
 	}
 
 	return nil
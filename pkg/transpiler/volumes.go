@@ -0,0 +1,270 @@
+package transpiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/SerRichard/proteus/pkg/cwl"
+	"github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	log "github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	tmpdirVolumeName      = "tmpdir"
+	tmpdirVolumeMountPath = "/tmp/proteus/tmpdir"
+)
+
+// VolumeStrategy selects the volume the output directory of a tool is
+// written to.
+type VolumeStrategy string
+
+const (
+	// VolumePVC claims a new volume for every run of the workflow
+	VolumePVC VolumeStrategy = "pvc"
+	// VolumeExistingClaim mounts a volume claimed beforehand
+	VolumeExistingClaim VolumeStrategy = "claim"
+	// VolumeEmptyDir mounts an empty directory on the node, which lives as
+	// long as the pod of a step
+	VolumeEmptyDir VolumeStrategy = "emptydir"
+	// VolumeNone mounts no volume
+	VolumeNone VolumeStrategy = "none"
+)
+
+var volumeStrategies = []VolumeStrategy{VolumePVC, VolumeExistingClaim, VolumeEmptyDir, VolumeNone}
+
+// VolumeOptions configure the volume shared by the steps of a workflow.
+type VolumeOptions struct {
+	// Strategy of the volume, when empty a volume is only claimed for
+	// standalone tools writing outputs which are not stored in an artifact
+	// repository
+	Strategy VolumeStrategy
+	// StorageClass of a claimed volume, the cluster default when empty
+	StorageClass string
+	// AccessMode of a claimed volume, ReadWriteMany when empty
+	AccessMode string
+	// Size of the volume, taken from outdirMin when empty
+	Size string
+	// ClaimName is the existing claim mounted by the claim strategy
+	ClaimName string
+	// GC is the volumeClaimGC strategy deleting claimed volumes
+	GC string
+}
+
+// Validate checks the options name a known strategy and carry what it needs.
+func (v VolumeOptions) Validate() error {
+	if v.Strategy != "" && !containsStrategy(volumeStrategies, v.Strategy) {
+		return fmt.Errorf("unknown volume strategy %s, expected one of %s", v.Strategy, joinStrategies(volumeStrategies))
+	}
+	if v.Strategy == VolumeExistingClaim && v.ClaimName == "" {
+		return fmt.Errorf("volume strategy %s requires the name of the claim", v.Strategy)
+	}
+	if v.Strategy != VolumeExistingClaim && v.ClaimName != "" {
+		return fmt.Errorf("a claim name is only used by the volume strategy %s", VolumeExistingClaim)
+	}
+
+	switch apiv1.PersistentVolumeAccessMode(v.AccessMode) {
+	case "", apiv1.ReadWriteOnce, apiv1.ReadOnlyMany, apiv1.ReadWriteMany, apiv1.ReadWriteOncePod:
+	default:
+		return fmt.Errorf("unknown access mode %s", v.AccessMode)
+	}
+
+	switch v1alpha1.VolumeClaimGCStrategy(v.GC) {
+	case "":
+	case v1alpha1.VolumeClaimGCOnCompletion, v1alpha1.VolumeClaimGCOnSuccess:
+		if v.Strategy != "" && v.Strategy != VolumePVC {
+			return fmt.Errorf("volumeClaimGC only applies to the volume strategy %s", VolumePVC)
+		}
+	default:
+		return fmt.Errorf("unknown volumeClaimGC strategy %s, expected %s or %s", v.GC, v1alpha1.VolumeClaimGCOnCompletion, v1alpha1.VolumeClaimGCOnSuccess)
+	}
+
+	if v.Size != "" {
+		if _, err := resource.ParseQuantity(v.Size); err != nil {
+			return fmt.Errorf("invalid volume size %s: %w", v.Size, err)
+		}
+	}
+	return nil
+}
+
+func containsStrategy(strategies []VolumeStrategy, strategy VolumeStrategy) bool {
+	for _, s := range strategies {
+		if s == strategy {
+			return true
+		}
+	}
+	return false
+}
+
+func joinStrategies(strategies []VolumeStrategy) string {
+	names := make([]string, 0, len(strategies))
+	for _, s := range strategies {
+		names = append(names, string(s))
+	}
+	return strings.Join(names, ", ")
+}
+
+// needsVolume reports whether a volume is mounted, required tells whether
+// the outputs are written to one when no strategy is chosen.
+func needsVolume(options VolumeOptions, required bool) bool {
+	switch options.Strategy {
+	case "":
+		return required
+	case VolumeNone:
+		return false
+	default:
+		return true
+	}
+}
+
+// outdirQuantity returns the outdirMin of the requirements, nil without one.
+func outdirQuantity(requirements cwl.Requirements) (*resource.Quantity, error) {
+	resourceReq, err := findResourceRequirement(requirements)
+	if err != nil || resourceReq.OutdirMin == nil {
+		return nil, nil
+	}
+	return expressionToQuantity(resourceReq.OutdirMin)
+}
+
+// volumeSize returns the size of the volume, the configured size takes
+// precedence over the outdirMin of the tools.
+func volumeSize(options VolumeOptions, outdirMin *resource.Quantity) (*resource.Quantity, error) {
+	if options.Size != "" {
+		quantity, err := resource.ParseQuantity(options.Size)
+		if err != nil {
+			return nil, err
+		}
+		return &quantity, nil
+	}
+	return outdirMin, nil
+}
+
+// emitVolume adds the volume of the chosen strategy to the workflow spec.
+func emitVolume(spec *v1alpha1.WorkflowSpec, options VolumeOptions, outdirMin *resource.Quantity) error {
+	size, err := volumeSize(options, outdirMin)
+	if err != nil {
+		return err
+	}
+
+	switch options.Strategy {
+	case "", VolumePVC:
+		if size == nil {
+			return resourceRequirementNotPresent()
+		}
+		return emitPVC(spec, options, *size)
+	case VolumeExistingClaim:
+		spec.Volumes = append(spec.Volumes, apiv1.Volume{
+			Name: volumeClaimName,
+			VolumeSource: apiv1.VolumeSource{
+				PersistentVolumeClaim: &apiv1.PersistentVolumeClaimVolumeSource{ClaimName: options.ClaimName},
+			},
+		})
+	case VolumeEmptyDir:
+		spec.Volumes = append(spec.Volumes, apiv1.Volume{
+			Name:         volumeClaimName,
+			VolumeSource: apiv1.VolumeSource{EmptyDir: &apiv1.EmptyDirVolumeSource{SizeLimit: size}},
+		})
+	}
+	return nil
+}
+
+func emitPVC(spec *v1alpha1.WorkflowSpec, options VolumeOptions, size resource.Quantity) error {
+	pSpec := apiv1.PersistentVolumeClaimSpec{}
+	resources := apiv1.ResourceRequirements{}
+	resourceMap := make(map[apiv1.ResourceName]resource.Quantity)
+
+	resourceMap[apiv1.ResourceStorage] = size
+	resources.Requests = resourceMap
+	pSpec.Resources = resources
+
+	accessMode := apiv1.ReadWriteMany
+	if options.AccessMode != "" {
+		accessMode = apiv1.PersistentVolumeAccessMode(options.AccessMode)
+	}
+	pSpec.AccessModes = []apiv1.PersistentVolumeAccessMode{accessMode}
+	if options.StorageClass != "" {
+		pSpec.StorageClassName = &options.StorageClass
+	}
+
+	pVolClaim := apiv1.PersistentVolumeClaim{}
+	pVolClaim.Spec = pSpec
+
+	pVolClaim.Name = volumeClaimName
+
+	spec.VolumeClaimTemplates = []apiv1.PersistentVolumeClaim{pVolClaim}
+	if options.GC != "" {
+		spec.VolumeClaimGC = &v1alpha1.VolumeClaimGC{Strategy: v1alpha1.VolumeClaimGCStrategy(options.GC)}
+	}
+	return nil
+}
+
+// emitWorkflowVolume adds the volume shared by the steps of a workflow, sized
+// by the largest outdirMin of its steps. An emptyDir is not shared, every
+// step gets its own, so it is rejected for steps reading files of others.
+func emitWorkflowVolume(spec *v1alpha1.WorkflowSpec, options VolumeOptions, workflow *cwl.Workflow) error {
+	if options.Strategy == VolumeEmptyDir {
+		if consumer, source, ok := stepFileLink(workflow); ok {
+			return fmt.Errorf("volume strategy %s is not shared across the steps of a workflow but step %s reads the files of %s, use %s or %s instead", VolumeEmptyDir, consumer, source, VolumePVC, VolumeExistingClaim)
+		}
+		log.Warnf("%s volumes are not shared across the steps of a workflow, every step gets its own", VolumeEmptyDir)
+	}
+
+	var outdirMin *resource.Quantity
+	for _, step := range workflow.Steps {
+		requirements := cwl.InheritRequirements(workflow.Requirements, step.Requirements, step.Run.Requirements)
+		quantity, err := outdirQuantity(requirements)
+		if err != nil {
+			return fmt.Errorf("step %s: %w", step.Id, err)
+		}
+		if quantity != nil && (outdirMin == nil || quantity.Cmp(*outdirMin) > 0) {
+			outdirMin = quantity
+		}
+	}
+	return emitVolume(spec, options, outdirMin)
+}
+
+// stepFileLink returns the first step reading a File or Directory output of
+// another step, along with the source it reads.
+func stepFileLink(workflow *cwl.Workflow) (string, string, bool) {
+	for _, step := range workflow.Steps {
+		_, inputs := orderedStepInputs(&step)
+		for _, input := range inputs {
+			for _, source := range input.Source {
+				scope, _ := splitSource(source)
+				if scope == globalScope {
+					continue
+				}
+				types := sourceTypes(workflow, source)
+				if len(types) > 0 && (cwl.IsAllFiles(types) || cwl.IsAllDirectories(types)) {
+					return step.Id, source, true
+				}
+			}
+		}
+	}
+	return "", "", false
+}
+
+// emitTmpdir mounts an empty directory sized by tmpdirMin as the TMPDIR of
+// the tool.
+func emitTmpdir(template *v1alpha1.Template, requirements cwl.Requirements) error {
+	resourceReq, err := findResourceRequirement(requirements)
+	if err != nil || resourceReq.TmpdirMin == nil {
+		return nil
+	}
+	size, err := expressionToQuantity(resourceReq.TmpdirMin)
+	if err != nil {
+		return err
+	}
+
+	template.Volumes = append(template.Volumes, apiv1.Volume{
+		Name:         tmpdirVolumeName,
+		VolumeSource: apiv1.VolumeSource{EmptyDir: &apiv1.EmptyDirVolumeSource{SizeLimit: size}},
+	})
+	template.Container.VolumeMounts = append(template.Container.VolumeMounts, apiv1.VolumeMount{
+		Name:      tmpdirVolumeName,
+		MountPath: tmpdirVolumeMountPath,
+	})
+	template.Container.Env = append(template.Container.Env, apiv1.EnvVar{Name: "TMPDIR", Value: tmpdirVolumeMountPath})
+	return nil
+}
@@ -0,0 +1 @@
+wc -l /etc/hostname
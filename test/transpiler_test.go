@@ -3,8 +3,10 @@ package testing
 import (
 	"log"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/SerRichard/proteus/pkg/cwl"
 	"github.com/SerRichard/proteus/pkg/transpiler"
 )
 
@@ -129,3 +131,1320 @@ func TestTranspileWorkflow(t *testing.T) {
 	}
 
 }
+
+func TestTranspileCommandLineToolOutputEval(t *testing.T) {
+
+	var input = "data/composite-cli/output-eval/output-eval.cwl"
+	var output = "data/composite-cli/output-eval/output-eval_argo_output.yaml"
+
+	err := transpiler.ProcessFile(input, "", "")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"path: /tmp/name.txt", "path: /tmp/proteus/outputs/line_count", "path: /tmp/proteus/outputs/summary", `'"comment"'; printf 'null'`} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+}
+
+func TestTranspileCommandLineToolGlobArray(t *testing.T) {
+
+	var input = "data/composite-cli/glob-array/glob-array.cwl"
+	var inputs_file = "data/composite-cli/glob-array/glob-array-job.yml"
+	var locations_file = "data/composite-cli/glob-array/glob-array-locations.json"
+	var output = "data/composite-cli/glob-array/glob-array_argo_output.yaml"
+
+	err := transpiler.ProcessFile(input, inputs_file, locations_file)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"path: /tmp/{{inputs.parameters.prefix}}.bam", "path: /tmp/proteus/outputs/variants", "name: variants-manifest"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+}
+
+func TestTranspileCommandLineToolExitCodes(t *testing.T) {
+
+	var input = "data/composite-cli/exit-codes/exit-codes.cwl"
+	var output = "data/composite-cli/exit-codes/exit-codes_argo_output.yaml"
+
+	err := transpiler.ProcessFile(input, "", "")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"expression: asInt(lastRetry.exitCode) in [75, 111]", `case "$rc" in 1) rc=0 ;; esac`} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+}
+
+func TestTranspileWorkflowStepBindings(t *testing.T) {
+
+	var input = "data/composite-cli/step-binding/workflow.cwl"
+	var output = "data/composite-cli/step-binding/workflow_argo_output.yaml"
+
+	err := transpiler.ProcessFile(input, "", "")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"- -n\n", "- '{{inputs.parameters.count}}'", "value: '{{workflow.parameters.wf_count}}'"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+	if strings.Contains(string(data), "{{inputs.parameters.unused}}") {
+		t.Errorf("inputs without an inputBinding should not be on the command line")
+	}
+	for _, unexpected := range []string{"- -q\n", "{{inputs.parameters.quiet}}", "name: header"} {
+		if strings.Contains(string(data), unexpected) {
+			t.Errorf("optional inputs left unbound should be omitted, found %q", unexpected)
+		}
+	}
+
+	// Required inputs must be bound by the step
+	err = transpiler.ProcessFile("data/composite-cli/step-binding/required.cwl", "", "")
+	if err == nil || !strings.Contains(err.Error(), "required input quiet is not bound on step head_lines") {
+		t.Errorf("expected the unbound required input to be rejected, got %v", err)
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+}
+
+func TestTranspileStepInputDefaults(t *testing.T) {
+
+	var input = "data/composite-cli/step-binding/array-default.cwl"
+	var output = "data/composite-cli/step-binding/array-default_argo_output.yaml"
+
+	err := transpiler.ProcessFile(input, "", "")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{`value: '["alice","bob"]'`, `value: '{"greeting":"hello"}'`} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+
+	// File defaults can not be passed as parameters
+	err = transpiler.ProcessFile("data/composite-cli/step-binding/file-default.cwl", "", "")
+	if err == nil || !strings.Contains(err.Error(), "File defaults of step inputs are not supported") {
+		t.Errorf("expected the File default to be rejected, got %v", err)
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+}
+
+func TestTranspileWorkflowStepArtifacts(t *testing.T) {
+
+	var input = "data/composite-cli/step-artifacts/workflow.cwl"
+	var output = "data/composite-cli/step-artifacts/workflow_argo_output.yaml"
+
+	err := transpiler.ProcessFile(input, "", "")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"from: '{{steps.write.outputs.artifacts.written}}'", "path: /tmp/proteus/inputs/source", "name: written"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+	if strings.Contains(string(data), "outputs.parameters.written") {
+		t.Errorf("File outputs should not be passed as parameters")
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+}
+
+func TestTranspileWorkflowOutputs(t *testing.T) {
+
+	var input = "data/composite-cli/workflow-outputs/workflow.cwl"
+	var locations = "data/composite-cli/workflow-outputs/workflow-locations.json"
+	var output = "data/composite-cli/workflow-outputs/workflow_argo_output.yaml"
+
+	err := transpiler.ProcessFile(input, "", locations)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"from: '{{steps.copy.outputs.artifacts.written}}'",
+		"parameter: '{{steps.read.outputs.parameters.name}}'",
+		"sprig.concat([steps['read'].outputs.parameters['name']], [workflow.parameters['target']])",
+		"key: hostname.txt",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+
+	// the_only_non_null can not fail in argo when several sources are set
+	err = transpiler.ProcessFile("data/composite-cli/workflow-outputs/only-non-null.cwl", "", locations)
+	if err == nil || !strings.Contains(err.Error(), "pickValue the_only_non_null is not supported for the output names") {
+		t.Errorf("expected the_only_non_null to be rejected, got %v", err)
+	}
+}
+
+func TestTranspileWorkflowMergedSources(t *testing.T) {
+
+	var input = "data/composite-cli/merge-sources/workflow.cwl"
+	var output = "data/composite-cli/merge-sources/workflow_argo_output.yaml"
+
+	err := transpiler.ProcessFile(input, "", "")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"{{=toJson(sprig.concat(",
+		"from: '{{steps.write-second.outputs.artifacts.written}}'",
+		"name: sources-1",
+		"- /tmp/proteus/inputs/sources/0\n",
+		// Merged numbers keep their type and the items follow the inputBinding
+		"toJson([asInt(steps[''write-first''].outputs.parameters[''lines'']), asInt(",
+		"- --counts\n                            - '{{inputs.parameters.counts-0}},{{inputs.parameters.counts-1}}'\n",
+		"- -n\n                            - '{{inputs.parameters.names-0}}'\n                            - '{{inputs.parameters.names-1}}'\n",
+		"value: '{{steps.write-second.outputs.parameters.lines}}'",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+}
+
+func TestTranspileWorkflowStepValueFrom(t *testing.T) {
+
+	var input = "data/composite-cli/step-value-from/workflow.cwl"
+	var output = "data/composite-cli/step-value-from/workflow_argo_output.yaml"
+
+	err := transpiler.ProcessFile(input, "", "")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"value: '{{=workflow.parameters[''sample'']}}.txt'",
+		"value: .bak",
+		"{{=sprig.default(''now'', workflow.parameters[''sample''])}}",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+}
+
+func TestTranspileWorkflowInheritedRequirements(t *testing.T) {
+
+	var input = "data/composite-cli/inherit-requirements/workflow.cwl"
+	var output = "data/composite-cli/inherit-requirements/workflow_argo_output.yaml"
+
+	err := transpiler.ProcessFile(input, "", "")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, image := range []string{"image: ubuntu:20.04", "image: alpine:3.19", "image: busybox:1.36"} {
+		if strings.Count(string(data), image) != 1 {
+			t.Errorf("expected %q once in the emitted workflow", image)
+		}
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+}
+
+func TestTranspileCommandLineToolHints(t *testing.T) {
+
+	var input = "data/composite-cli/hints/hints.cwl"
+	var output = "data/composite-cli/hints/hints_argo_output.yaml"
+
+	err := transpiler.ProcessFile(input, "", "")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The DockerRequirement hint applies while the ToolTimeLimit requirement overrides its hint
+	for _, expected := range []string{"image: ubuntu:20.04", "activeDeadlineSeconds: 600"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+}
+
+func TestTranspileWorkflowPodHints(t *testing.T) {
+
+	var input = "data/composite-cli/pod-hints/workflow.cwl"
+	var output = "data/composite-cli/pod-hints/workflow_argo_output.yaml"
+
+	err := transpiler.ProcessFile(input, "", "")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"serviceAccountName: cwl-runner",
+		"- name: registry-credentials",
+		"accelerator: nvidia",
+		"key: nvidia.com/gpu",
+		"priorityClassName: high-priority",
+		"runAsUser: 1000",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+}
+
+func TestTranspileInputFormats(t *testing.T) {
+
+	var input = "data/composite-cli/formats/formats.cwl"
+	var output = "data/composite-cli/formats/formats_argo_output.yaml"
+	var locations = "data/composite-cli/formats/formats-locations.json"
+
+	// FASTA is a textual format through FASTA-like in the ontology
+	err := transpiler.ProcessFile(input, "data/composite-cli/formats/formats-job.yml", locations)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+
+	// BAM is a binary format
+	err = transpiler.ProcessFile(input, "data/composite-cli/formats/formats-mismatch-job.yml", locations)
+	if err == nil {
+		t.Error("expected an incompatible format to be rejected")
+	} else if !strings.Contains(err.Error(), "format_2572") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// Files of arrays and records are checked as well
+	nested := "data/composite-cli/formats/formats-nested.cwl"
+	for job, id := range map[string]string{
+		"data/composite-cli/formats/formats-array-mismatch-job.yml":  "batches[1]",
+		"data/composite-cli/formats/formats-record-mismatch-job.yml": "sample.reads",
+	} {
+		err = transpiler.ProcessFile(nested, job, "")
+		if err == nil || !strings.Contains(err.Error(), "format_2572 of input "+id) {
+			t.Errorf("expected the format of %s to be rejected, got %v", id, err)
+		}
+	}
+
+	// Records pass the type check but can not be passed to the tool
+	err = transpiler.ProcessFile(nested, "data/composite-cli/formats/formats-record-job.yml", "")
+	if err == nil || !strings.Contains(err.Error(), "record input sample is not supported") {
+		t.Errorf("expected the record input to be rejected, got %v", err)
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+}
+
+func TestTranspileImports(t *testing.T) {
+
+	var input = "data/composite-cli/imports/imports.cwl"
+	var output = "data/composite-cli/imports/imports_argo_output.yaml"
+
+	err := transpiler.ProcessFile(input, "", "")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"image: ubuntu:22.04",
+		"wc -l /etc/hostname",
+		"name: pattern",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+
+	err = transpiler.ProcessFile("data/composite-cli/imports/cyclic.cwl", "", "")
+	if err == nil || !strings.Contains(err.Error(), "cyclic $import") {
+		t.Errorf("expected a cyclic $import error, got %v", err)
+	}
+}
+
+func TestTranspilePackedGraph(t *testing.T) {
+
+	var input = "data/composite-cli/packed/packed.cwl"
+	var output = "data/composite-cli/packed/packed_argo_output.yaml"
+
+	for _, tc := range []struct {
+		entrypoint string
+		expected   []string
+	}{
+		{"", []string{"name: greet", "image: alpine:3.19", "name: message"}},
+		{"#echo", []string{"image: alpine:3.19", "name: text"}},
+	} {
+		err := transpiler.ProcessFileWithOptions(input, "", "", transpiler.Options{Entrypoint: tc.entrypoint})
+		if err != nil {
+			t.Logf("Error caught %d", err)
+			t.Fail()
+		}
+
+		data, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, expected := range tc.expected {
+			if !strings.Contains(string(data), expected) {
+				t.Errorf("expected %q in the workflow emitted for %q", expected, tc.entrypoint)
+			}
+		}
+
+		if _, err := os.Stat(output); err == nil {
+			e := os.Remove(output)
+			if e != nil {
+				log.Fatal(e)
+			}
+		}
+	}
+
+	err := transpiler.ProcessFileWithOptions(input, "", "", transpiler.Options{Entrypoint: "#missing"})
+	if err == nil || !strings.Contains(err.Error(), "#missing not found") {
+		t.Errorf("expected a missing entrypoint error, got %v", err)
+	}
+}
+
+func TestTranspileCwltoolPacked(t *testing.T) {
+
+	// Packed by cwltool --pack, with list-form inputs and steps and #main/ ids
+	var input = "data/composite-cli/packed/cwltool-packed.json"
+	var output = "data/composite-cli/packed/cwltool-packed_argo_output.yaml"
+
+	err := transpiler.ProcessFile(input, "", "data/composite-cli/workflow-outputs/workflow-locations.json")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"value: '{{workflow.parameters.target}}'",
+		"from: '{{steps.copy.outputs.artifacts.written}}'",
+		"parameter: '{{steps.read.outputs.parameters.name}}'",
+		"[workflow.parameters['target']]",
+		"- '{{inputs.parameters.target}}'",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+	if strings.Contains(string(data), "#main") || strings.Contains(string(data), ".cwl/") {
+		t.Error("expected the packed ids to be normalised")
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+}
+
+func TestPackWorkflow(t *testing.T) {
+
+	var input = "data/composite-cli/pack/workflow.cwl"
+	var packed = "data/composite-cli/pack/packed.cwl"
+	var output = "data/composite-cli/pack/packed_argo_output.yaml"
+
+	data, err := cwl.Pack(input, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"$graph:", "id: '#main'", "run: '#echo'", "run: '#shout'"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the packed document", expected)
+		}
+	}
+	if strings.Count(string(data), "id: '#echo'") != 1 {
+		t.Error("expected the shared tool to be packed once")
+	}
+
+	err = os.WriteFile(packed, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = transpiler.ProcessFile(packed, "", "")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	for _, file := range []string{packed, output} {
+		if _, err := os.Stat(file); err == nil {
+			e := os.Remove(file)
+			if e != nil {
+				log.Fatal(e)
+			}
+		}
+	}
+
+	// Run files are searched in the search path when packing as well
+	data, err = cwl.Pack("data/composite-cli/search-path/workflow.cwl", "", []string{"data/composite-cli/search-path/lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "baseCommand: echo") {
+		t.Error("expected the tool found in the search path to be packed")
+	}
+
+	// Local ontologies stay relative to where the packed document is written
+	data, err = cwl.Pack("data/composite-cli/formats/formats-nested.cwl", "data/composite-cli", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "- formats/EDAM_subset.owl") {
+		t.Error("expected the ontology relative to the packed document")
+	}
+	if wd, err := os.Getwd(); err == nil && strings.Contains(string(data), wd) {
+		t.Error("expected no host paths in the packed document")
+	}
+}
+
+func TestTranspileRunPaths(t *testing.T) {
+
+	// Run files are resolved relative to the workflow, not the working directory
+	var input = "data/composite-cli/whalesay/workflow.cwl"
+	var output = "data/composite-cli/whalesay/workflow_argo_output.yaml"
+	var locations = "data/composite-cli/whalesay/whalesay-locations.json"
+
+	err := transpiler.ProcessFile(input, "", locations)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+
+	input = "data/composite-cli/search-path/workflow.cwl"
+	output = "data/composite-cli/search-path/workflow_argo_output.yaml"
+
+	err = transpiler.ProcessFile(input, "", "")
+	if err == nil || !strings.Contains(err.Error(), "could not find the file: shared-echo.cwl") {
+		t.Errorf("expected shared-echo.cwl not to be found without a search path, got %v", err)
+	}
+
+	err = transpiler.ProcessFileWithOptions(input, "", "", transpiler.Options{SearchPath: []string{"data/composite-cli/search-path/lib"}})
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "name: shared") {
+		t.Error("expected the step running the tool found in the search path")
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+
+	// The search path only applies to the call it is given to
+	err = transpiler.ProcessFile(input, "", "")
+	if err == nil || !strings.Contains(err.Error(), "could not find the file: shared-echo.cwl") {
+		t.Errorf("expected the search path not to outlive its call, got %v", err)
+	}
+}
+
+func TestTranspileInputNamedRun(t *testing.T) {
+
+	var input = "data/composite-cli/inputs/run-input.cwl"
+	var output = "data/composite-cli/inputs/run-input_argo_output.yaml"
+
+	// Only the run field of a step references a document
+	err := transpiler.ProcessFile(input, "data/composite-cli/inputs/run-input-job.yml", "")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "name: run") {
+		t.Error("expected the input named run in the emitted workflow")
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+
+	_, err = cwl.Pack(input, "", nil)
+	if err != nil {
+		t.Errorf("expected the tool to be packed, got %v", err)
+	}
+}
+
+func TestTranspileUpgradedCommandLineTool(t *testing.T) {
+
+	var input = "data/composite-cli/upgrade/legacy.cwl"
+	var output = "data/composite-cli/upgrade/legacy_argo_output.yaml"
+
+	err := transpiler.ProcessFile(input, "data/composite-cli/upgrade/legacy-job.yml", "data/composite-cli/upgrade/legacy-locations.json")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cwltool:TimeLimit became the standard ToolTimeLimit in v1.1
+	if !strings.Contains(string(data), "activeDeadlineSeconds: 120") {
+		t.Error("expected the upgraded time limit in the emitted workflow")
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+
+	err = transpiler.ProcessFile("data/composite-cli/upgrade/unsupported.cwl", "", "")
+	if err == nil || !strings.Contains(err.Error(), "unsupported cwlVersion draft-3") {
+		t.Errorf("expected draft-3 to be rejected, got %v", err)
+	}
+
+	err = transpiler.ProcessFile("data/composite-cli/upgrade/unversioned.cwl", "", "")
+	if err == nil || !strings.Contains(err.Error(), "unversioned.cwl: cwlVersion is required") {
+		t.Errorf("expected the missing cwlVersion to be rejected, got %v", err)
+	}
+}
+
+func TestUpgradeInitialWorkDirListing(t *testing.T) {
+
+	data, err := cwl.Pack("data/composite-cli/upgrade/listing.cwl", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A Dirent without entryname is a listing expression in v1.2
+	for _, expected := range []string{"- $(inputs.lines)", "entryname: settings.conf"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the upgraded document", expected)
+		}
+	}
+	if strings.Contains(string(data), "entry: $(inputs.lines)") {
+		t.Error("expected the Dirent without entryname to be replaced")
+	}
+
+	// v1.2 would write the array as JSON instead of staging its Files
+	err = transpiler.ProcessFile("data/composite-cli/upgrade/listing-array.cwl", "", "")
+	if err == nil || !strings.Contains(err.Error(), "returns the array input reads") {
+		t.Errorf("expected the array Dirent to be rejected, got %v", err)
+	}
+}
+
+func TestTranspileJSONDocuments(t *testing.T) {
+
+	var input = "data/composite-cli/json/echo.json"
+	var output = "data/composite-cli/json/echo_argo_output.yaml"
+
+	// The job order is read from stdin
+	job, err := os.Open("data/composite-cli/json/echo-job.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer job.Close()
+
+	stdin := os.Stdin
+	os.Stdin = job
+	defer func() { os.Stdin = stdin }()
+
+	err = transpiler.ProcessFile(input, "-", "")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"name: json-echo",
+		"value: \"3\"",
+		"- '{{inputs.parameters.message}}'",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+}
+
+func TestTranspileDocumentsWithoutExtension(t *testing.T) {
+
+	var input = "data/composite-cli/json/shout-workflow"
+	var output = "data/composite-cli/json/shout-workflow_argo_output.yaml"
+
+	// Neither the workflow nor the tool it runs have a CWL extension
+	err := transpiler.ProcessFile(input, "", "")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"name: shout-workflow", "name: loud", "- echo"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+}
+
+func TestTranspileJobOrder(t *testing.T) {
+
+	var input = "data/composite-cli/job-order/align.cwl"
+	var output = "data/composite-cli/job-order/align_argo_output.yaml"
+	var locations = "data/composite-cli/job-order/align-locations.json"
+
+	err := transpiler.ProcessFile(input, "data/composite-cli/job-order/align-job.yml", locations)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"- /data/reference",
+		"- /tmp/proteus/inputs/reads/reads.fq",
+		"value: \"0.5\"",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+	// threads is null so its prefix is not on the command line
+	if strings.Contains(string(data), "- -t") {
+		t.Error("expected the null threads input to be left out")
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		e := os.Remove(output)
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+
+	err = transpiler.ProcessFile(input, "data/composite-cli/job-order/align-mismatch-job.yml", locations)
+	if err == nil || !strings.Contains(err.Error(), "reads: Directory does not match File") {
+		t.Errorf("expected a Directory given for a File to be rejected, got %v", err)
+	}
+}
+
+func TestTranspileLocationURIs(t *testing.T) {
+
+	var input = "data/composite-cli/location-uris/merge.cwl"
+	var output = "data/composite-cli/location-uris/merge_argo_output.yaml"
+	var locations = "data/composite-cli/location-uris/merge-locations.json"
+
+	err := transpiler.ProcessFile(input, "data/composite-cli/location-uris/merge-job.yml", locations)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"bucket: samples",
+		"key: batch-1/samples.csv",
+		"endpoint: minio:9000",
+		"name: minio-credentials",
+		"bucket: annotations",
+		"key: genes.gff",
+		"url: https://example.com/data/manifest.txt",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+
+	err = os.Remove(output)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	// A File literal is staged from its contents
+	err = transpiler.ProcessFile(input, "data/composite-cli/location-uris/merge-literal-job.yml", locations)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err = os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"data: samples.csv genes.gff",
+		"path: /tmp/proteus/inputs/manifest/manifest.txt",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+
+	err = os.Remove(output)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+}
+
+func TestTranspileWorkflowDirectoryLocation(t *testing.T) {
+
+	var input = "data/composite-cli/location-uris/workflow.cwl"
+	var output = "data/composite-cli/location-uris/workflow_argo_output.yaml"
+
+	err := transpiler.ProcessFile(input, "data/composite-cli/location-uris/workflow-job.yml", "")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The remote Directory is an argument artifact of the workflow
+	for _, expected := range []string{
+		"bucket: references",
+		"key: genome/",
+		"from: '{{inputs.artifacts.reference}}'",
+		"path: /tmp/proteus/inputs/genome",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+
+	err = os.Remove(output)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+}
+
+func TestTranspileArtifactDrivers(t *testing.T) {
+
+	var input = "data/composite-cli/artifact-drivers/build.cwl"
+	var output = "data/composite-cli/artifact-drivers/build_argo_output.yaml"
+	var inputs = "data/composite-cli/artifact-drivers/build-job.yml"
+
+	err := transpiler.ProcessFile(input, inputs, "data/composite-cli/artifact-drivers/build-locations.json")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"repo: https://github.com/example/project.git",
+		"revision: v1.0.0",
+		"container: configs",
+		"name: azure-credentials",
+		"url: https://artifactory.example.com/toolchains/toolchain.tar",
+		"name: artifactory-credentials",
+		"key: project/build.out",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+
+	err = os.Remove(output)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	err = transpiler.ProcessFile(input, inputs, "data/composite-cli/artifact-drivers/build-invalid-locations.json")
+	if err == nil || !strings.Contains(err.Error(), "input config: azure location requires container") {
+		t.Errorf("expected the azure location without a container to be rejected, got %v", err)
+	}
+}
+
+func TestTranspileArtifactRepository(t *testing.T) {
+
+	var input = "data/composite-cli/artifact-repository/sort.cwl"
+	var output = "data/composite-cli/artifact-repository/sort_argo_output.yaml"
+
+	err := transpiler.ProcessFileWithOptions(input, "data/composite-cli/artifact-repository/sort-job.yml", "", transpiler.Options{ArtifactRepositoryRef: "artifact-repositories/minio"})
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"configMap: artifact-repositories",
+		"key: minio",
+		"key: datasets/unsorted.txt",
+		"{{workflow.name}}/sorted",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+	// Outputs are uploaded to the repository, no volume is claimed for them
+	if strings.Contains(string(data), "volumeClaimTemplates") {
+		t.Error("expected no volume claim with an artifact repository")
+	}
+
+	err = os.Remove(output)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+}
+
+func TestTranspileVolumeStrategies(t *testing.T) {
+
+	var input = "data/composite-cli/volumes/workflow.cwl"
+	var output = "data/composite-cli/volumes/workflow_argo_output.yaml"
+
+	err := transpiler.ProcessFileWithOptions(input, "", "", transpiler.Options{Volume: transpiler.VolumeOptions{
+		Strategy:     transpiler.VolumePVC,
+		StorageClass: "fast",
+		AccessMode:   "ReadWriteOnce",
+		GC:           "OnWorkflowCompletion",
+	}})
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"storageClassName: fast",
+		"- ReadWriteOnce",
+		"storage: 2Gi",
+		"strategy: OnWorkflowCompletion",
+		"sizeLimit: 512Mi",
+		"value: /tmp/proteus/tmpdir",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+	// The claim and a mount in each of the two steps
+	if count := strings.Count(string(data), "name: argovolume"); count != 3 {
+		t.Errorf("expected the volume to be shared by both steps, found it %d times", count)
+	}
+
+	err = os.Remove(output)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	input = "data/composite-cli/volumes/tool.cwl"
+	output = "data/composite-cli/volumes/tool_argo_output.yaml"
+
+	err = transpiler.ProcessFileWithOptions(input, "", "", transpiler.Options{Volume: transpiler.VolumeOptions{
+		Strategy:  transpiler.VolumeExistingClaim,
+		ClaimName: "shared-data",
+	}})
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err = os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "claimName: shared-data") {
+		t.Error("expected the existing claim to be mounted")
+	}
+	if strings.Contains(string(data), "volumeClaimTemplates") {
+		t.Error("expected no volume to be claimed")
+	}
+
+	err = os.Remove(output)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	err = transpiler.ProcessFileWithOptions(input, "", "", transpiler.Options{Volume: transpiler.VolumeOptions{Strategy: transpiler.VolumeExistingClaim}})
+	if err == nil || !strings.Contains(err.Error(), "requires the name of the claim") {
+		t.Errorf("expected the claim strategy without a claim to be rejected, got %v", err)
+	}
+
+	// An emptyDir is not shared, the second step could not read the file of the first
+	err = transpiler.ProcessFileWithOptions("data/composite-cli/volumes/workflow.cwl", "", "", transpiler.Options{Volume: transpiler.VolumeOptions{Strategy: transpiler.VolumeEmptyDir}})
+	if err == nil || !strings.Contains(err.Error(), "step count_words reads the files of write/written") {
+		t.Errorf("expected the emptydir strategy to be rejected, got %v", err)
+	}
+}
+
+func TestTranspileOutputArchives(t *testing.T) {
+
+	var input = "data/composite-cli/archives/report.cwl"
+	var output = "data/composite-cli/archives/report_argo_output.yaml"
+	var locations = "data/composite-cli/archives/report-locations.json"
+
+	err := transpiler.ProcessFile(input, "", locations)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"none: {}",
+		"compressionLevel: 6",
+		"zip: {}",
+		"strategy: OnWorkflowDeletion",
+		"strategy: Never",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+
+	err = os.Remove(output)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	level := int32(3)
+	err = transpiler.ProcessFileWithOptions(input, "", locations, transpiler.Options{Artifacts: cwl.ArtifactOptions{Archive: cwl.ArchiveZip, CompressionLevel: &level}})
+	if err == nil || !strings.Contains(err.Error(), "compressionLevel only applies to tar archives") {
+		t.Errorf("expected a compression level of zip archives to be rejected, got %v", err)
+	}
+
+	// The compression level of an output conflicts with a zip archive of all outputs
+	err = transpiler.ProcessFileWithOptions(input, "", "data/composite-cli/archives/report-level-locations.json", transpiler.Options{Artifacts: cwl.ArtifactOptions{Archive: cwl.ArchiveZip}})
+	if err == nil || !strings.Contains(err.Error(), "output figures: compressionLevel only applies to tar archives") {
+		t.Errorf("expected the compression level of a zip archived output to be rejected, got %v", err)
+	}
+}
+
+func TestTranspileFileChecksums(t *testing.T) {
+
+	var input = "data/composite-cli/checksums/compress.cwl"
+	var output = "data/composite-cli/checksums/compress_argo_output.yaml"
+	var locations = "data/composite-cli/checksums/compress-locations.json"
+
+	err := transpiler.ProcessFile(input, "data/composite-cli/checksums/compress-job.yml", locations)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"proteus_verify_file '/tmp/proteus/inputs/raw/sample.txt' 'sha1' '2aae6c35c94fcfb415dbe95f408b9ce91ee846ed' '11'",
+		"sha1sum '/tmp/proteus/inputs/raw/sample.txt.gz'",
+		// The checksum tool is checked before it is used
+		"proteus_require_command sha1sum 'record the checksum of output Files'",
+		`proteus_require_command "$2sum" "verify the checksum of $1"`,
+		"printf null > /tmp/proteus/outputs/compressed.size",
+		"name: compressed-checksum",
+		"path: /tmp/proteus/outputs/compressed.checksum",
+		"name: compressed-size",
+		"path: /tmp/proteus/outputs/compressed.size",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the emitted workflow", expected)
+		}
+	}
+
+	err = os.Remove(output)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	err = transpiler.ProcessFile(input, "data/composite-cli/checksums/compress-invalid-job.yml", locations)
+	if err == nil || !strings.Contains(err.Error(), "checksum algorithm crc32 is not supported") {
+		t.Errorf("expected the crc32 checksum to be rejected, got %v", err)
+	}
+}
+
+func TestTranspileWorkflowFileChecksums(t *testing.T) {
+
+	var input = "data/composite-cli/checksums/workflow.cwl"
+	var output = "data/composite-cli/checksums/workflow_argo_output.yaml"
+
+	err := transpiler.ProcessFile(input, "data/composite-cli/checksums/compress-job.yml", "")
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "proteus_verify_file '/tmp/proteus/inputs/source' 'sha1' '2aae6c35c94fcfb415dbe95f408b9ce91ee846ed' '11'"
+	if !strings.Contains(string(data), expected) {
+		t.Errorf("expected %q in the emitted step", expected)
+	}
+
+	err = os.Remove(output)
+	if err != nil {
+		t.Logf("Error caught %d", err)
+		t.Fail()
+	}
+}